@@ -6,68 +6,66 @@ package apns
 
 import (
 	"encoding/binary"
-	"errors"
-	"fmt"
 	"encoding/json"
 	"github.com/cfilipov/apns/format"
 	"io"
 )
 
-// UnknwonCommandErr error is used when APN data is encountered with a
-// command that is unknown.
-var UnknwonCommandErr = errors.New("Unknown command ID.")
-
 // Notification represents a specific set of APNs packets which are
 // used for delivering push notifications.
 type PushNotification interface {
 	PushNotification()
-	ReadFrom(r io.Reader) error
-	WriteTo(w io.Writer) error
+	io.ReaderFrom
+	io.WriterTo
 	String() string
+
+	// Text describes the notification in the given style. Its JSON style
+	// is the form read by MakeNotification, whatever format.StringStyle is.
+	Text(style format.Style) string
 }
 
 // Packet represents the various data formats that may be encountered
 // when communicating with APNs.
 type Packet interface {
-	ReadFrom(r io.Reader) error
+	io.ReaderFrom
 	String() string
-	WriteTo(w io.Writer) error
+	io.WriterTo
 }
 
-func MakeNotification(data []byte) (pn PushNotification) {
-	var notif format.Command
-	json.Unmarshal(data, &notif)
+// MakeNotification decodes a notification from its JSON form, choosing the
+// format by the "command" field. An unknown command results in
+// ErrUnknownCommand. Fields of the wrong type are reported, but unknown
+// fields are ignored; use format.ValidateJSON to check a document fully.
+func MakeNotification(data []byte) (pn PushNotification, err error) {
+	var notif format.CommandHeader
+	err = json.Unmarshal(data, &notif)
+	if err != nil {
+		return
+	}
 
 	switch notif.Command {
-	case 0:
-		var n format.SimpleNotification
-		json.Unmarshal([]byte(data), &n)
-		pn = n
-		return
-	case 1:
-		var n format.EnhancedNotification
-		json.Unmarshal([]byte(data), &n)
-		pn = n
-		return
-	case 2:
-		var n format.Notification
-		json.Unmarshal([]byte(data), &n)
-		pn = n
-		return
+	case format.SimpleNotificationCMD:
+		pn = new(format.SimpleNotification)
+	case format.EnhancedNotificationCMD:
+		pn = new(format.EnhancedNotification)
+	case format.NotificationCMD:
+		pn = new(format.Notification)
+	default:
+		return nil, ErrUnknownCommand
+	}
+
+	err = json.Unmarshal(data, pn)
+	if err != nil {
+		return nil, err
 	}
 	return
 }
 
 // ReadCommand will read an APNs data format from an input stream and
-// return a Packet if successful.
+// return a Packet if successful. An unknown command results in
+// ErrUnknownCommand and a packet which fails to decode in a *ParseError.
 func ReadCommand(r io.Reader) (p Packet, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("Bad input.\n")
-		}
-	}()
-
-	var command int8
+	var command format.Command
 	err = binary.Read(r, binary.BigEndian, &command)
 	if err != nil {
 		return
@@ -78,18 +76,39 @@ func ReadCommand(r io.Reader) (p Packet, err error) {
 		p = new(format.SimpleNotification)
 	case format.EnhancedNotificationCMD:
 		p = new(format.EnhancedNotification)
+	case format.NotificationCMD:
+		p = new(format.Notification)
 	case format.NotificationErrorCMD:
 		p = new(format.NotificationError)
 	default:
-		err = UnknwonCommandErr
+		err = ErrUnknownCommand
 		return
 	}
 
-	err = p.ReadFrom(r)
+	rec := &recordingReader{r: r, tail: []byte{byte(command)}, n: 1}
+	_, err = p.ReadFrom(rec)
 	if err != nil {
-		fmt.Println("Reading packet failed")
+		p, err = nil, newParseError(command, p, rec, err)
 		return
 	}
 
 	return
 }
+
+// recordingReader keeps the last bytes of a packet read through it, for
+// the hexdump of a ParseError.
+type recordingReader struct {
+	r    io.Reader
+	tail []byte
+	n    int64
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.n += int64(n)
+	rr.tail = append(rr.tail, p[:n]...)
+	if over := len(rr.tail) - maxParseContext; over > 0 {
+		rr.tail = append(rr.tail[:0], rr.tail[over:]...)
+	}
+	return n, err
+}
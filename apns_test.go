@@ -0,0 +1,47 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"testing"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// TestMakeNotificationRoundTrip checks that what String writes passes
+// ValidateJSON and reads back with MakeNotification.
+func TestMakeNotificationRoundTrip(t *testing.T) {
+	token := testNotification(1).Token
+	payload := format.JSON{"aps": map[string]interface{}{"alert": "test"}}
+	tests := []struct {
+		name string
+		pn   apns.PushNotification
+	}{
+		{"simple", &format.SimpleNotification{Token: token, Payload: payload}},
+		{"simple nil payload", &format.SimpleNotification{Token: token}},
+		{"enhanced", &format.EnhancedNotification{Token: token, Identifier: 7, Expiry: 1000, Payload: payload}},
+		{"enhanced nil payload", &format.EnhancedNotification{Token: token, Identifier: 7}},
+		{"zero priority", &format.Notification{Token: token, Payload: payload}},
+		{"priority", &format.Notification{Token: token, Identifier: 7, Priority: 5, Payload: payload}},
+		{"items", &format.Notification{Token: token, Payload: payload, Items: []format.FrameItem{{Number: 9, Data: []byte{1, 2}}}}},
+		{"raw payload", testNotification(2)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text := test.pn.(interface{ String() string }).String()
+			if err := format.ValidateJSON([]byte(text)); err != nil {
+				t.Fatalf("ValidateJSON(%s): %v", text, err)
+			}
+			pn, err := apns.MakeNotification([]byte(text))
+			if err != nil {
+				t.Fatalf("MakeNotification(%s): %v", text, err)
+			}
+			if got := pn.(interface{ String() string }).String(); got != text {
+				t.Errorf("read back as %s, want %s", got, text)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// recipient is a device to send to, with the fields of its -csv row.
+type recipient struct {
+	token  format.Token
+	fields map[string]string
+}
+
+// payloadColumns are the -csv columns which take the place of the payload
+// argument of the same name. Any other column is a custom payload key.
+var payloadColumns = map[string]bool{
+	"alert":             true,
+	"badge":             true,
+	"sound":             true,
+	"content-available": true,
+	"category":          true,
+	"thread-id":         true,
+}
+
+// readCSV reads the recipients of a -csv file. The first row names the
+// columns, one of which must be device-token:
+//
+// 		device-token,badge,alert,user
+// 		beefca5e...,3,Hello Alice,alice
+// 		f00df00d...,1,Hello Bob,bob
+//
+// Empty cells are left out of the fields, so that the argument of the same
+// name applies instead.
+func readCSV(file string) ([]recipient, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%s: empty", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tokenColumn := -1
+	for i, name := range header {
+		if name == "device-token" {
+			tokenColumn = i
+		}
+		if name == "aps" {
+			return nil, fmt.Errorf("%s: %v", file, format.ErrReservedKey)
+		}
+	}
+	if tokenColumn < 0 {
+		return nil, fmt.Errorf("%s: no device-token column", file)
+	}
+
+	var recipients []recipient
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return recipients, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+		token, err := format.ParseToken(row[tokenColumn])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+		fields := make(map[string]string)
+		for i, value := range row {
+			if i == tokenColumn || value == "" {
+				continue
+			}
+			fields[header[i]] = value
+		}
+		if badge, ok := fields["badge"]; ok {
+			if _, err := strconv.Atoi(badge); err != nil {
+				return nil, fmt.Errorf("%s:%d: badge must be a number", file, line)
+			}
+		}
+		if ca, ok := fields["content-available"]; ok {
+			if _, err := strconv.ParseBool(ca); err != nil {
+				return nil, fmt.Errorf("%s:%d: content-available must be 1 or 0", file, line)
+			}
+		}
+		recipients = append(recipients, recipient{token, fields})
+	}
+}
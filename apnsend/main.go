@@ -17,12 +17,13 @@ import (
 	"github.com/cfilipov/apns/format"
 	"net"
 	"os"
+	"strconv"
 	"time"
 )
 
-var token = flag.String("device-token", "", "A custom APNs gateway (for testing or proxy)")
+var token = flag.String("device-token", "", "The device token to send to, in hexadecimal (spaces are allowed) or base64 (standard or URL-safe)")
 var notifJSON = flag.String("notification-json", "", "A custom APNs gateway (for testing or proxy)")
-var notifCMD = flag.Int("command", 2, "An identifier specifying the apns binary data format to use. 0: Simple, 1: Enhanced, 2:Default")
+var notifCMD = format.NotificationCMD
 var customGateway = flag.String("apn-gateway", "", "A custom APNs gateway (for testing or proxy)")
 var tcpDelay = flag.Bool("tcp-delay", false, "Determines weather to delay TCP packet until it's full")
 var verbose = flag.Bool("v", false, "Verbose output")
@@ -34,17 +35,35 @@ var pemFile = flag.String("pem", "apns.pem", "X.509 certificate/key pair stored
 var sandbox = flag.Bool("sandbox", false, "Indicates the push notification should use the sandbox environment")
 var badge = flag.String("badge", "", "Badge value to use in payload")
 var sound = flag.String("sound", "", "Notification sound key")
-var contentAvailable = flag.String("content-available", "", "Provide this key with a value of 1 to indicate that new content is available. This is used to support Newsstand apps and background content downloads.")
-var alert = flag.String("alert", "", "Alert text to send as an APN alert")
-var payload = flag.String("payload", "", "Raw (JSON) payload to send. This overrides all other aps payload arguments such as -text -badge and -sound options.")
+var contentAvailable = flag.String("content-available", "", "Provide this key with a value of 1 (or true) to indicate that new content is available; 0 (or false) leaves it out. This is used to support Newsstand apps and background content downloads.")
+var category = flag.String("category", "", "Notification category identifier, selecting the actions shown with the notification")
+var threadID = flag.String("thread-id", "", "Identifier used to group related notifications together")
+var alert = flag.String("alert", "", "Alert text to send as an APN alert. This is a Go template, executed for each device with its token as {{.Token}}, its -csv columns and the -var variables.")
+var payload = flag.String("payload", "", "Raw (JSON) payload to send. This overrides all other aps payload arguments such as -text -badge -sound -category and -thread-id options. It is a template like -alert; {{json .key}} quotes a value as a JSON string.")
+var csvFile = flag.String("csv", "", "CSV file of devices to send to, one per row, with a header row naming the columns. The device-token column is required; alert, badge, sound, content-available, category and thread-id columns take the place of those arguments for the row, and any other column is added to the payload as a custom key.")
 var ttl = flag.Int("ttl", 0, "Time-to-live, in seconds. Signifies how long to wait before the notification can be discarded by APNs. Differs from --expiry in that --expiry requires an actual UNIX time stamp. If both flags are provided, expiry takes precedence.")
 
 func init() {
+	flag.Func("command", "The apns binary data format to use, by name or number: simple (0), enhanced (1) or notification (2, the default)", func(s string) error {
+		c, err := format.ParseCommand(s)
+		if err != nil {
+			return err
+		}
+		switch c {
+		case format.SimpleNotificationCMD, format.EnhancedNotificationCMD, format.NotificationCMD:
+			notifCMD = c
+			return nil
+		}
+		return fmt.Errorf("%s is not a notification format", c)
+	})
+	flag.Func("var", "A key=value variable for the -payload and -alert templates, used as {{.key}}. May be repeated; a -csv column of the same name takes precedence.", setTemplateVar)
+	flag.TextVar(&format.StringStyle, "packet-format", format.JSONStyle, "How packets are printed: json, compact (one line per packet) or verbose (compact with a hexdump)")
+	flag.BoolVar(&format.LogFullTokens, "full-tokens", false, "Print device tokens in full instead of only their first and last 4 bytes")
 	flag.Parse()
 
 	flag.Usage = func() {
-		fmt.Println("apnsend - Push notification sending utility for Apple's Push Notification system (APNs)\n")
-		fmt.Fprintf(os.Stderr, "Usage: apnsend -pem <certificate> -alert <text> -device-token <token> \n")
+		fmt.Print("apnsend - Push notification sending utility for Apple's Push Notification system (APNs)\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: apnsend -pem <certificate> -alert <text> -device-token <token> [token ...]\n")
 		flag.PrintDefaults()
 		fmt.Println("\nTo convert a pkcs#12 (.p12) certificate+key pair to pem, use opensll:")
 		fmt.Println("\topenssl pkcs12 -in CertificateName.p12 -out CertificateName.pem -nodes")
@@ -56,8 +75,16 @@ func main() {
 
 	// Sanity check the arguments.
 
-	if *token == "" {
-		fmt.Println("Missing argument: -device-token")
+	// Tokens after the options are sent the same notification as
+	// -device-token, each with its own identifier, and then the rows of
+	// -csv.
+	tokenArgs := flag.Args()
+	if *token != "" {
+		tokenArgs = append([]string{*token}, tokenArgs...)
+	}
+
+	if len(tokenArgs) == 0 && *csvFile == "" {
+		fmt.Println("Missing argument: -device-token or -csv")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -66,22 +93,33 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
-	if *payload == "" && *alert == "" && *badge == "" && *sound == "" && *contentAvailable == "" {
+	if *payload == "" && *alert == "" && *badge == "" && *sound == "" && *contentAvailable == "" && *csvFile == "" {
 		fmt.Println("Missing argument: -payload, -alert, -badge, -sound, or -content-available required")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// Calculate the expiry, if applicable.
-
-	var expiryTime int32 // Expiry = Specific DateTime, TTL = Length of Time
+	if err := parseTemplates(); err != nil {
+		fmt.Printf("\nERROR: %s\n", err)
+		os.Exit(1)
+	}
 
-	if *expiry != 0 {
-		expiryTime = int32(*expiry)
+	var recipients []recipient
+	for _, arg := range tokenArgs {
+		deviceToken, err := format.ParseToken(arg)
+		if err != nil {
+			fmt.Printf("\nERROR: %s: %s\n", arg, err)
+			os.Exit(1)
+		}
+		recipients = append(recipients, recipient{token: deviceToken})
 	}
-	if *ttl != 0 {
-		unixTime := int32(time.Now().Unix())
-		expiryTime = unixTime + int32(*ttl)
+	if *csvFile != "" {
+		rows, err := readCSV(*csvFile)
+		if err != nil {
+			fmt.Printf("\nERROR: %s\n", err)
+			os.Exit(1)
+		}
+		recipients = append(recipients, rows...)
 	}
 
 	// Load the certificate.
@@ -127,93 +165,227 @@ func main() {
 
 	defer conn.Close()
 
-	// Listen for error responses.
+	// Create a notification instance for each token.
 
-	go func() {
-		for {
-			p, err := apns.ReadCommand(conn)
-			if err != nil {
-				fmt.Printf("\nERROR: %s\n", err)
-				os.Exit(1)
+	var notifs []apns.PushNotification
+
+	if *notifJSON != "" {
+		if len(recipients) > 1 || *csvFile != "" {
+			fmt.Println("\nERROR: -notification-json names its own device token, it cannot be sent to several")
+			os.Exit(1)
+		}
+		err = format.ValidateJSON([]byte(*notifJSON))
+		if err != nil {
+			fmt.Printf("\nERROR: -notification-json: %s\n", err)
+			os.Exit(1)
+		}
+		notif, err := apns.MakeNotification([]byte(*notifJSON))
+		if err != nil {
+			fmt.Printf("\nERROR: %s\n", err)
+			os.Exit(1)
+		}
+		notifs = append(notifs, notif)
+	} else {
+		ids := apns.NewIdentifierAllocator()
+		for _, r := range recipients {
+			var notif apns.PushNotification
+			p, err := makePayload(r)
+			if err == nil {
+				notif, err = makeNotification(r.token, p, ids)
 			}
 			if err != nil {
-				fmt.Printf("\nAPNs Response: %s\n", p)
+				fmt.Printf("\nERROR: %s\n", err)
 				os.Exit(1)
 			}
+			notifs = append(notifs, notif)
 		}
-	}()
+	}
 
-	// Create a notification instance.
+	// Write the notifications to output, all on the one connection. APNs
+	// hangs up after rejecting one, so stop writing if it has.
 
-	var notif apns.PushNotification
+	var writeErr error
+	for _, notif := range notifs {
+		if *verbose {
+			fmt.Printf("Sending: %s\n", notif)
+		}
+		if _, writeErr = notif.WriteTo(conn); writeErr != nil {
+			break
+		}
+	}
 
-	if *notifJSON != "" {
-		notif = apns.MakeNotification([]byte(*notifJSON))
-	} else {
-		var p format.JSON
+	// Wait for a short time before quitting to give APNs a chance to
+	// return error responses, if any.
 
-		// Create a payload unless one is provided by the -payload argument.
+	resp, err := apns.ReadErrorResponse(conn, 5000*time.Millisecond)
+	if resp != nil {
+		fmt.Printf("\nAPNs Response: %s\n", resp)
+		reportRejected(notifs, resp)
+		os.Exit(1)
+	}
+	if writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		fmt.Printf("\nERROR: %s\n", err)
+		os.Exit(1)
+	}
 
-		if len(*payload) == 0 {
-			p = make(map[string]interface{})
-			aps := map[string]string{}
-			if *alert != "" {
-				aps["alert"] = *alert
-			}
-			if *badge != "" {
-				aps["badge"] = *badge
-			}
-			if *sound != "" {
-				aps["sound"] = *sound
+	return
+}
+
+// makePayload creates the payload for a recipient from the payload
+// arguments, unless one is provided by the -payload argument. The fields
+// of a -csv row take the place of the arguments of the same name, and any
+// other fields are added as custom keys.
+func makePayload(r recipient) (format.JSON, error) {
+	fields := r.fields
+	var p format.JSON
+	if len(*payload) != 0 {
+		text, err := execTemplate(payloadTmpl, r)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(text), &p); err != nil {
+			return nil, fmt.Errorf("-payload for %s: %s", r.token.Hex(), err)
+		}
+	} else {
+		arg := func(name, value string) string {
+			if v, ok := fields[name]; ok {
+				return v
 			}
-			if *contentAvailable != "" {
-				aps["content-available"] = *contentAvailable
+			return value
+		}
+		b := apns.NewPayload()
+		alertText, ok := fields["alert"]
+		if !ok {
+			var err error
+			if alertText, err = execTemplate(alertTmpl, r); err != nil {
+				return nil, err
 			}
-			p["aps"] = aps
+		}
+		if alertText != "" {
+			b.Alert(alertText)
+		}
+		if s := arg("badge", *badge); s != "" {
+			n, err := strconv.Atoi(s)
 			if err != nil {
-				fmt.Printf("\nERROR: %s\n", err)
-				os.Exit(1)
+				return nil, fmt.Errorf("-badge must be a number: %s", err)
 			}
-		} else {
-			json.Unmarshal([]byte(*payload), &p)
+			b.Badge(n)
 		}
-
-		// Create a notification instance.
-
-		if *notifCMD == 0 {
-			notif = format.SimpleNotification{
-				Token:   *token,
-				Payload: p,
-			}
-		} else if *notifCMD == 1 {
-			notif = format.EnhancedNotification{
-				Identifier: 1, 
-				Expiry:     expiryTime,
-				Token:      *token,
-				Payload:    p,
+		if s := arg("sound", *sound); s != "" {
+			b.Sound(s)
+		}
+		if s := arg("content-available", *contentAvailable); s != "" {
+			on, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("-content-available must be 1 or 0: %s", err)
 			}
-		} else { // *notifCMD == 2
-			notif = format.Notification{
-				Identifier: 1,
-				Expiry:     expiryTime,
-				Token:      *token,
-				Priority:   int8(*priority),
-				Payload:    p,
+			if on {
+				b.ContentAvailable()
 			}
 		}
+		if s := arg("category", *category); s != "" {
+			b.Category(s)
+		}
+		if s := arg("thread-id", *threadID); s != "" {
+			b.ThreadID(s)
+		}
+		var err error
+		if p, err = b.JSONFor(notifCMD); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range fields {
+		if !payloadColumns[name] {
+			p.Set(name, value)
+		}
 	}
+	return p, nil
+}
 
-	// Write the notification to output.
+// makeNotification creates a notification to a token in the format chosen
+// with -command. Each is given the next identifier of ids.
+func makeNotification(deviceToken format.Token, p format.JSON, ids *apns.IdentifierAllocator) (apns.PushNotification, error) {
+	switch notifCMD {
+	case format.SimpleNotificationCMD:
+		return &format.SimpleNotification{
+			Token:   deviceToken,
+			Payload: p,
+		}, nil
+	case format.EnhancedNotificationCMD:
+		en := &format.EnhancedNotification{
+			Identifier: ids.Next(),
+			Token:      deviceToken,
+			Payload:    p,
+		}
+		return en, setExpiry(en)
+	}
+	n := &format.Notification{
+		Identifier: ids.Next(),
+		Token:      deviceToken,
+		Priority:   int8(*priority),
+		Payload:    p,
+	}
+	return n, setExpiry(n)
+}
 
-	if *verbose {
-		fmt.Printf("Sending: %s\n", notif)
+// reportRejected prints the token of the notification an error response
+// identifies, and the tokens of the notifications sent after it, which
+// APNs discards. The Shutdown status identifies the last notification
+// accepted rather than one rejected.
+func reportRejected(notifs []apns.PushNotification, resp *apns.ErrorResponse) {
+	for i, notif := range notifs {
+		var id int32
+		switch n := notif.(type) {
+		case *format.EnhancedNotification:
+			id = n.Identifier
+		case *format.Notification:
+			id = n.Identifier
+		default:
+			continue
+		}
+		if id != resp.Identifier {
+			continue
+		}
+		if resp.Status != format.ShutdownStatus {
+			fmt.Printf("Rejected: %s\n", notifToken(notif).Hex())
+		}
+		for _, notif := range notifs[i+1:] {
+			fmt.Printf("Discarded: %s\n", notifToken(notif).Hex())
+		}
+		return
 	}
-	notif.WriteTo(conn)
+}
 
-	// Wait for a short time before quitting to give APNs a chance to
-	// return error responses, if any.
+// notifToken returns the device token of a notification.
+func notifToken(notif apns.PushNotification) format.Token {
+	switch n := notif.(type) {
+	case *format.SimpleNotification:
+		return n.Token
+	case *format.EnhancedNotification:
+		return n.Token
+	case *format.Notification:
+		return n.Token
+	}
+	return format.Token{}
+}
 
-	time.Sleep(5000 * time.Millisecond)
+// expirySetter is implemented by the notification formats with an expiry.
+type expirySetter interface {
+	SetExpiry(t time.Time) error
+	SetTTL(ttl time.Duration) error
+}
 
-	return
+// setExpiry applies the -expiry or -ttl argument, if given, to a
+// notification. Expiry takes precedence.
+func setExpiry(n expirySetter) error {
+	if *expiry != 0 {
+		return n.SetExpiry(time.Unix(int64(*expiry), 0))
+	}
+	if *ttl != 0 {
+		return n.SetTTL(time.Duration(*ttl) * time.Second)
+	}
+	return nil
 }
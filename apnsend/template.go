@@ -0,0 +1,78 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// -payload and -alert are text/template templates, executed for each
+// recipient with these variables:
+//
+// 		{{.Token}}         the device token, in hexadecimal
+// 		{{.user}}          the user column of its -csv row, if any
+// 		{{.env}}           a variable given with -var env=staging
+// 		{{json .name}}     a variable quoted as a JSON string, for -payload
+//
+// A column whose name is not a Go identifier is written as
+// {{index . "first-name"}}. Using a variable which is not set is an error.
+var (
+	payloadTmpl *template.Template
+	alertTmpl   *template.Template
+)
+
+// templateVars are the variables set with -var.
+var templateVars = make(map[string]string)
+
+// setTemplateVar sets a variable given as key=value.
+func setTemplateVar(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	templateVars[key] = value
+	return nil
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseTemplates parses the -payload and -alert templates.
+func parseTemplates() (err error) {
+	if payloadTmpl, err = parseTemplate("-payload", *payload); err != nil {
+		return err
+	}
+	alertTmpl, err = parseTemplate("-alert", *alert)
+	return err
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// execTemplate executes a template for a recipient. The columns of its
+// -csv row take precedence over -var variables of the same name.
+func execTemplate(t *template.Template, r recipient) (string, error) {
+	data := make(map[string]string, len(templateVars)+len(r.fields)+1)
+	for k, v := range templateVars {
+		data[k] = v
+	}
+	for k, v := range r.fields {
+		data[k] = v
+	}
+	data["Token"] = r.token.Hex()
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// AdminOptions contains options for the admin HTTP endpoint.
+type AdminOptions struct {
+	port int
+}
+
+// adminMux routes the requests of the admin endpoint.
+var adminMux = http.NewServeMux()
+
+// received broadcasts every notification the server reads.
+var received = &broadcaster{subs: make(map[chan []byte]bool)}
+
+// receivedCount counts the notifications received on all connections.
+var receivedCount uint64
+
+// pushEvent describes a received notification to the admin stream.
+type pushEvent struct {
+	Time         time.Time       `json:"time"`
+	Remote       string          `json:"remote"`
+	Notification json.RawMessage `json:"notification"`
+}
+
+// broadcaster hands events to every current subscriber. A subscriber too
+// slow to keep up misses events rather than holding up the server.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan []byte]bool
+}
+
+func (b *broadcaster) subscribe() chan []byte {
+	ch := make(chan []byte, 64)
+	b.mu.Lock()
+	b.subs[ch] = true
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *broadcaster) publish(event []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publishPush sends a notification received from a client to the admin
+// stream. Tokens are shown in full, as the stream is for development.
+func publishPush(remote net.Addr, pn apns.PushNotification) {
+	atomic.AddUint64(&receivedCount, 1)
+	event, err := json.Marshal(pushEvent{
+		Time:         time.Now(),
+		Remote:       remote.String(),
+		Notification: json.RawMessage(pn.Text(format.JSONStyle)),
+	})
+	if err != nil {
+		logger.Warn("Error publishing notification", "err", err)
+		return
+	}
+	received.publish(event)
+}
+
+func init() {
+	adminMux.HandleFunc("/events", handleEvents)
+	adminMux.HandleFunc("/status", handleStatus)
+}
+
+// serverStatus describes the services of the server and the state they
+// share.
+type serverStatus struct {
+	Gateway          string `json:"gateway"`
+	Feedback         string `json:"feedback,omitempty"`
+	HTTP2            string `json:"http2,omitempty"`
+	Admin            string `json:"admin"`
+	Connections      int32  `json:"connections"`
+	Received         uint64 `json:"received"`
+	PendingFeedback  int    `json:"pending-feedback"`
+	RegisteredTokens *int   `json:"registered-tokens,omitempty"`
+}
+
+// handleStatus responds with the JSON of a serverStatus:
+//
+// 		curl http://localhost:8080/status
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := serverStatus{
+		Gateway:         fmt.Sprintf("0.0.0.0:%d", connOptions.port),
+		Admin:           fmt.Sprintf("0.0.0.0:%d", adminOptions.port),
+		Connections:     atomic.LoadInt32(&activeConns),
+		Received:        atomic.LoadUint64(&receivedCount),
+		PendingFeedback: failedTokens.len(),
+	}
+	if connOptions.unixSocket != "" {
+		status.Gateway = "unix://" + connOptions.unixSocket
+	}
+	if feedbackOptions.port != 0 {
+		status.Feedback = fmt.Sprintf("0.0.0.0:%d", feedbackOptions.port)
+	}
+	if http2Options.port != 0 {
+		status.HTTP2 = fmt.Sprintf("0.0.0.0:%d", http2Options.port)
+	}
+	if n, restricted := currentOptions().tokens.len(); restricted {
+		status.RegisteredTokens = &n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleEvents streams received notifications as server-sent events, one
+// "push" event per notification with the JSON of a pushEvent as its data:
+//
+// 		curl -N http://localhost:8080/events
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := received.subscribe()
+	defer received.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case event := <-ch:
+			if _, err := fmt.Fprintf(w, "event: push\ndata: %s\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveAdmin starts the admin HTTP endpoint in the background.
+func serveAdmin(adminOpts *AdminOptions) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", adminOpts.port))
+	if err != nil {
+		return err
+	}
+	logger.Info("Admin endpoint listening", "port", adminOpts.port)
+	go func() {
+		if err := http.Serve(ln, adminMux); err != nil {
+			logger.Error("Admin endpoint stopped", "err", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/cfilipov/apns"
+)
+
+// How a response is mangled by -chaos.
+const (
+	chaosTruncate = "truncate" // only the start of the frame is sent
+	chaosGarbage  = "garbage"  // random bytes are sent instead of the frame
+	chaosSplit    = "split"    // the frame is sent in pieces, split mid-field
+)
+
+var chaosModes = []string{chaosTruncate, chaosGarbage, chaosSplit}
+
+// writeResponse writes an error response to a client. With -chaos it is
+// sometimes mangled instead, as a hostile or buggy peer might send it, to
+// test that clients reading it cope.
+func writeResponse(conn net.Conn, resp *apns.ErrorResponse, rng *rand.Rand, mockErrOpts *MockErrOptions, log *slog.Logger) error {
+	if rng.Intn(100) >= mockErrOpts.chaos {
+		log.Debug("Responding", packetAttr(resp))
+		_, err := resp.WriteTo(conn)
+		return err
+	}
+	var buf bytes.Buffer
+	resp.WriteTo(&buf)
+	frame := buf.Bytes()
+	mode := chaosModes[rng.Intn(len(chaosModes))]
+	log.Info("Mangling response", "chaos", mode, packetAttr(resp))
+	switch mode {
+	case chaosTruncate:
+		_, err := conn.Write(frame[:1+rng.Intn(len(frame)-1)])
+		return err
+	case chaosGarbage:
+		rng.Read(frame)
+		_, err := conn.Write(frame)
+		return err
+	}
+	for len(frame) > 0 {
+		n := 1 + rng.Intn(3)
+		if n > len(frame) {
+			n = len(frame)
+		}
+		if _, err := conn.Write(frame[:n]); err != nil {
+			return err
+		}
+		frame = frame[n:]
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
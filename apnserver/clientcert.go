@@ -0,0 +1,132 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+var (
+	// The subject attribute holding the bundle ID of an APNs certificate.
+	oidUID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+	// The extension listing the topics of a universal APNs certificate.
+	oidTopics = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 3, 6}
+)
+
+// tlsConfig returns the TLS configuration for the server, or nil if no
+// certificate is given. Client certificates are asked for only when they
+// are to be verified or checked for a topic.
+func tlsConfig(cer *tls.Certificate, authOpts *AuthOptions) (*tls.Config, error) {
+	if cer == nil {
+		if authOpts.caFile != "" || authOpts.requireClientCert || authOpts.topic != "" {
+			return nil, errors.New("verifying client certificates needs a server certificate+key pair")
+		}
+		return nil, nil
+	}
+	config := &tls.Config{
+		Certificates:       []tls.Certificate{*cer},
+		InsecureSkipVerify: true,
+	}
+	switch {
+	case authOpts.caFile != "":
+		data, err := ioutil.ReadFile(authOpts.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", authOpts.caFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if authOpts.requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	case authOpts.requireClientCert:
+		config.ClientAuth = tls.RequireAnyClientCert
+	case authOpts.topic != "":
+		config.ClientAuth = tls.RequestClientCert
+	}
+	return config, nil
+}
+
+// verifyClient completes the TLS handshake with a client, logs the topics
+// of its certificate and checks that it is issued for the topic required,
+// if any. Connections without TLS are not checked.
+func verifyClient(conn net.Conn, log *slog.Logger, authOpts *AuthOptions) error {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		if authOpts.topic != "" {
+			return fmt.Errorf("no client certificate for topic %s", authOpts.topic)
+		}
+		return nil
+	}
+	topics := certTopics(certs[0])
+	log.Debug("Client certificate", "subject", certs[0].Subject.CommonName, "topics", strings.Join(topics, ","))
+	if authOpts.topic == "" {
+		return nil
+	}
+	for _, t := range topics {
+		if t == authOpts.topic {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q is not issued for topic %s", certs[0].Subject.CommonName, authOpts.topic)
+}
+
+// certTopics returns the topics an APNs certificate may push to: the
+// bundle ID in its subject, and any others listed by a universal
+// certificate.
+func certTopics(cert *x509.Certificate) []string {
+	var topics []string
+	seen := make(map[string]bool)
+	add := func(topic string) {
+		if topic != "" && !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	for _, name := range cert.Subject.Names {
+		if s, ok := name.Value.(string); ok && name.Type.Equal(oidUID) {
+			add(s)
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTopics) {
+			continue
+		}
+		// The topics are strings, each followed by a sequence of the
+		// kinds of push they allow.
+		var items []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &items); err != nil {
+			continue
+		}
+		for _, item := range items {
+			if item.Class != asn1.ClassUniversal {
+				continue
+			}
+			switch item.Tag {
+			case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String:
+				add(string(item.Bytes))
+			}
+		}
+	}
+	return topics
+}
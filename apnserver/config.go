@@ -0,0 +1,250 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/cfilipov/apns/format"
+	"gopkg.in/yaml.v3"
+)
+
+// A config file sets options by their flag names, and the port or Unix
+// socket to listen on with port:
+//
+// 		port: 2195
+// 		pem: server.pem
+// 		fail: 10
+// 		fail-status: [8:50, 7:30, 10:20]
+// 		latency: 80ms
+// 		tokens: tokens.txt
+// 		record: session.ndjson
+//
+// Options given on the command line override the file. A list is the same
+// as its items separated by commas.
+
+// reloadable are the options which take effect again when the config file
+// is reloaded. The others only take effect when the server starts, among
+// them -packet-format and -full-tokens, which set variables of the format
+// package read without synchronization whenever a packet is logged.
+var reloadable = map[string]bool{
+	"v":              true,
+	"log-level":      true,
+	"fail":           true,
+	"fail-status":    true,
+	"drop":           true,
+	"chaos":          true,
+	"latency":        true,
+	"latency-jitter": true,
+	"throttle":       true,
+	"rate":           true,
+	"max-conns":      true,
+	"shutdown-after": true,
+	"rules":          true,
+	"tokens":         true,
+}
+
+// loadedConfig are the values of the config file last applied.
+var loadedConfig map[string]string
+
+// liveOptions are the options connections act on which a reload can
+// change. A reload sets the option variables and publishes a new snapshot
+// of them rather than changing the one connections may be reading, so
+// only the goroutine loading the config touches the variables.
+type liveOptions struct {
+	mockErr MockErrOptions
+	conn    ConnOptions
+
+	// Rules from the -rules file, by token, and the registered tokens.
+	rules  map[format.Token]tokenRule
+	tokens *tokenSet
+}
+
+// live is the snapshot of the options in effect.
+var live atomic.Pointer[liveOptions]
+
+// currentOptions returns the options in effect. A connection loads them
+// for each notification, so that a reload applies to it from the next one.
+func currentOptions() *liveOptions {
+	return live.Load()
+}
+
+// publishOptions makes the option variables, with the given rules and
+// registered tokens, the options in effect.
+func publishOptions(rules map[format.Token]tokenRule, tokens *tokenSet) {
+	live.Store(&liveOptions{
+		mockErr: *mockErrOptions,
+		conn:    *connOptions,
+		rules:   rules,
+		tokens:  tokens,
+	})
+}
+
+// readConfig reads the option values of a config file.
+func readConfig(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	values := make(map[string]string, len(doc))
+	for name, v := range doc {
+		if name == "config" || (name != "port" && flag.Lookup(name) == nil) {
+			return nil, fmt.Errorf("%s: unknown option %q", file, name)
+		}
+		value, err := configValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", file, name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// configValue returns a value of a config file as given on the command
+// line.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case []interface{}, map[string]interface{}:
+				return "", errors.New("lists must be of plain values")
+			}
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		return "", errors.New("expected a value or a list, not a mapping")
+	}
+	return fmt.Sprint(v), nil
+}
+
+// commandLineFlags returns the names of the flags given on the command
+// line.
+func commandLineFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyConfig sets options to the values of a config file, skipping those
+// given on the command line. It returns the values the options had before,
+// which are restored if setting any of them fails.
+func applyConfig(values map[string]string, explicit map[string]bool) (map[string]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if name != "port" && !explicit[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	old := make(map[string]string, len(names))
+	for _, name := range names {
+		f := flag.Lookup(name)
+		old[name] = f.Value.String()
+		if err := f.Value.Set(values[name]); err != nil {
+			restoreConfig(old)
+			return nil, fmt.Errorf("invalid value %q for %s: %v", values[name], name, err)
+		}
+	}
+	return old, nil
+}
+
+// restoreConfig sets options back to the values returned by applyConfig.
+func restoreConfig(old map[string]string) {
+	for name, value := range old {
+		flag.Lookup(name).Value.Set(value)
+	}
+}
+
+// reloadOnHangup reloads the config file whenever the process receives
+// SIGHUP.
+func reloadOnHangup(file string, explicit map[string]bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(file, explicit); err != nil {
+			logger.Error("Error reloading config, keeping the current options", "file", file, "err", err)
+			continue
+		}
+		logger.Info("Reloaded config", "file", file)
+	}
+}
+
+// reloadConfig applies the reloadable options of the config file again,
+// and reloads the -rules and -tokens files. A reloadable option no longer
+// in the file goes back to its default. Changes to the other options are
+// ignored with a warning, as they need a restart.
+//
+// Tokens registered through the admin endpoint are forgotten if a -tokens
+// file is reloaded.
+func reloadConfig(file string, explicit map[string]bool) error {
+	values, err := readConfig(file)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]string)
+	for name, value := range values {
+		if reloadable[name] {
+			live[name] = value
+		} else if !explicit[name] && value != loadedConfig[name] {
+			logger.Warn("Option changed in the config file needs a restart", "option", name)
+		}
+	}
+	for name := range loadedConfig {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		if reloadable[name] {
+			live[name] = flag.Lookup(name).DefValue
+		} else if !explicit[name] {
+			logger.Warn("Option removed from the config file needs a restart", "option", name)
+		}
+	}
+
+	old, err := applyConfig(live, explicit)
+	if err != nil {
+		return err
+	}
+	if err := checkMockErrOptions(mockErrOptions); err != nil {
+		restoreConfig(old)
+		return err
+	}
+	var rules map[format.Token]tokenRule
+	if connOptions.rulesFile != "" {
+		if rules, err = loadRules(connOptions.rulesFile); err != nil {
+			restoreConfig(old)
+			return err
+		}
+	}
+	tokens := currentOptions().tokens
+	if connOptions.tokensFile != "" {
+		if tokens, err = loadTokens(connOptions.tokensFile); err != nil {
+			restoreConfig(old)
+			return err
+		}
+	}
+	publishOptions(rules, tokens)
+	setLogLevel(logOptions, cmdOptions.verbose)
+	loadedConfig = values
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// FeedbackOptions contains options for the simulated feedback service.
+type FeedbackOptions struct {
+	port int
+	file string
+}
+
+// failedTokens collects the tokens rejected with the InvalidToken status,
+// which (like Apple's) the feedback service reports once and then forgets.
+var failedTokens = &tokenList{}
+
+// tokenList is a concurrency-safe list of feedback tuples.
+type tokenList struct {
+	mu     sync.Mutex
+	tuples []format.Feedback
+}
+
+// add records a token as having failed now.
+func (l *tokenList) add(token format.Token) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tuples = append(l.tuples, format.Feedback{
+		Timestamp: uint32(time.Now().Unix()),
+		Token:     token,
+	})
+}
+
+// len returns the number of tuples waiting to be reported.
+func (l *tokenList) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.tuples)
+}
+
+// drain returns every recorded tuple and empties the list.
+func (l *tokenList) drain() []format.Feedback {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tuples := l.tuples
+	l.tuples = nil
+	return tuples
+}
+
+// restore puts back tuples taken by drain which could not be reported,
+// ahead of any recorded since.
+func (l *tokenList) restore(tuples []format.Feedback) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tuples = append(tuples[:len(tuples):len(tuples)], l.tuples...)
+}
+
+// serveFeedback starts the simulated feedback service in the background.
+// Each client that connects is sent the tuples from the feedback file,
+// followed by any tokens rejected as invalid since the last client
+// connected, and then the connection is closed.
+func serveFeedback(config *tls.Config, fbOpts *FeedbackOptions) error {
+	var tuples []format.Feedback
+	if fbOpts.file != "" {
+		var err error
+		tuples, err = loadFeedback(fbOpts.file)
+		if err != nil {
+			return err
+		}
+	}
+
+	ln, err := listenPort(config, fbOpts.port)
+	if err != nil {
+		return err
+	}
+	logger.Info("Feedback service listening", "port", fbOpts.port, "tuples", len(tuples))
+
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				logger.Error("Unexpected error while accepting feedback connection", "err", err)
+				return
+			}
+			log := connLogger("feedback", newConnID(), client.RemoteAddr().String())
+			log.Debug("Connected")
+			go handleFeedbackClient(client, log, tuples)
+		}
+	}()
+	return nil
+}
+
+// handleFeedbackClient writes the feedback tuples of the -feedback file,
+// followed by those for the tokens failed since the last client, to a
+// client and hangs up. A client refused by verifyClient, or one the tuples
+// cannot be written to, leaves the failed tokens for the next.
+func handleFeedbackClient(conn net.Conn, log *slog.Logger, tuples []format.Feedback) {
+	defer conn.Close()
+	if err := verifyClient(conn, log, authOptions); err != nil {
+		log.Warn("Refused client", "err", err)
+		return
+	}
+	failed := failedTokens.drain()
+	if err := writeFeedback(conn, log, append(tuples[:len(tuples):len(tuples)], failed...)); err != nil {
+		log.Debug("Closing connection", "err", err)
+		failedTokens.restore(failed)
+	}
+}
+
+// writeFeedback writes feedback tuples to a client.
+func writeFeedback(conn net.Conn, log *slog.Logger, tuples []format.Feedback) error {
+	w := bufio.NewWriter(conn)
+	for _, fb := range tuples {
+		log.Debug("Feedback", packetAttr(&fb))
+		if _, err := fb.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// loadFeedback reads feedback tuples from a file of JSON lines.
+func loadFeedback(file string) ([]format.Feedback, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []format.Feedback
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var fb format.Feedback
+		if err := dec.Decode(&fb); err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, fb)
+	}
+	return tuples, nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+func TestHandleFeedbackClient(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	file := []format.Feedback{{Timestamp: 1, Token: format.Token{1}}}
+	failedTokens.drain()
+	defer failedTokens.drain()
+	failedTokens.add(format.Token{2})
+	failed := failedTokens.tuples[0]
+
+	// A client which hangs up before reading leaves the failed tokens.
+	client, server := net.Pipe()
+	client.Close()
+	handleFeedbackClient(server, log, file)
+	if n := failedTokens.len(); n != 1 {
+		t.Fatalf("%d failed tokens after a broken connection, want 1", n)
+	}
+
+	// A token failing meanwhile is reported after the one put back.
+	failedTokens.add(format.Token{3})
+	want := []format.Feedback{file[0], failed, failedTokens.tuples[1]}
+	client, server = net.Pipe()
+	go handleFeedbackClient(server, log, file)
+	got, err := apns.ReadFeedback(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("client read %v, want %v", got, want)
+	}
+	if n := failedTokens.len(); n != 0 {
+		t.Errorf("%d failed tokens after they were reported, want 0", n)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// HTTP2Options contains options for the mock of the HTTP/2 provider API.
+type HTTP2Options struct {
+	port int
+}
+
+// http2PayloadSize is the largest payload the HTTP/2 provider API accepts.
+const http2PayloadSize = 4096
+
+// apnsIDPattern matches an apns-id header, a UUID in its canonical form.
+var apnsIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// http2Reasons are the HTTP status and reason responded with for each
+// status of the binary interface. Unknown statuses are internal errors.
+var http2Reasons = map[format.Status]struct {
+	code   int
+	reason string
+}{
+	format.ProcessingErrorsStatus:   {http.StatusInternalServerError, "InternalServerError"},
+	format.MissingTokenStatus:       {http.StatusBadRequest, "MissingDeviceToken"},
+	format.MissingTopicStatus:       {http.StatusBadRequest, "MissingTopic"},
+	format.MissingPayloadStatus:     {http.StatusBadRequest, "PayloadEmpty"},
+	format.InvalidTokenSizeStatus:   {http.StatusBadRequest, "BadDeviceToken"},
+	format.InvalidTopicSizeStatus:   {http.StatusBadRequest, "BadTopic"},
+	format.InvalidPayloadSizeStatus: {http.StatusRequestEntityTooLarge, "PayloadTooLarge"},
+	format.InvalidTokenStatus:       {http.StatusBadRequest, "BadDeviceToken"},
+	format.ShutdownStatus:           {http.StatusServiceUnavailable, "Shutdown"},
+}
+
+// http2Error is the response to a rejected request.
+type http2Error struct {
+	code int
+
+	Reason string `json:"reason"`
+
+	// When the token stopped being valid, in milliseconds since the
+	// epoch, for 410 Unregistered only.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+func (e *http2Error) Error() string {
+	return fmt.Sprintf("%d %s", e.code, e.Reason)
+}
+
+// serveHTTP2 starts the mock of the HTTP/2 provider API in the background.
+// It uses TLS like the gateway if a configuration is given, and otherwise
+// HTTP/2 without TLS (h2c, with prior knowledge).
+func serveHTTP2(config *tls.Config, h2Opts *HTTP2Options) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", h2Opts.port))
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleHTTP2Push)
+	srv := &http.Server{Handler: mux}
+	logger.Info("HTTP/2 provider API listening", "port", h2Opts.port, "tls", config != nil)
+	go func() {
+		var err error
+		if config != nil {
+			srv.TLSConfig = config.Clone()
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			srv.Protocols = new(http.Protocols)
+			srv.Protocols.SetHTTP1(true)
+			srv.Protocols.SetUnencryptedHTTP2(true)
+			err = srv.Serve(ln)
+		}
+		logger.Error("HTTP/2 provider API stopped", "err", err)
+	}()
+	return nil
+}
+
+// handleHTTP2Push answers a POST to /3/device/<token> as the HTTP/2
+// provider API would: 200 with the apns-id, or an error status with a JSON
+// reason. The request is made into a command 2 notification, which goes
+// through the same rules, registered tokens and mock errors as one
+// received by the gateway, and to the admin stream and database.
+func handleHTTP2Push(w http.ResponseWriter, r *http.Request) {
+	id := newConnID()
+	log := connLogger("http2", id, r.RemoteAddr)
+	opts := currentOptions()
+	rng := connRand(&opts.mockErr, id)
+	time.Sleep(latency(rng, &opts.conn))
+
+	apnsID := r.Header.Get("apns-id")
+	if apnsID == "" {
+		apnsID = newAPNsID(rng)
+	}
+	w.Header().Set("apns-id", apnsID)
+
+	n, err := http2Notification(r, apnsID)
+	if err == nil {
+		log.Debug("Received", packetAttr(n))
+		remote := httpRemoteAddr(r)
+		publishPush(remote, n)
+		if notificationStore != nil {
+			notificationStore.save(remote, n)
+		}
+		err = http2Mock(rng, opts, n, log)
+	}
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var resp *http2Error
+	if !errors.As(err, &resp) {
+		var errResp *apns.ErrorResponse
+		errors.As(err, &errResp)
+		resp = http2ErrorFor(errResp)
+	}
+	log.Debug("Responding", "status", resp.code, "reason", resp.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// http2Notification checks a request and makes it into a notification.
+// The identifier is the last 8 hexadecimal digits of the apns-id, so that
+// requests made by apns.NewHTTP2Request keep their identifiers.
+func http2Notification(r *http.Request, apnsID string) (*apns.Notification, error) {
+	if r.Method != http.MethodPost {
+		return nil, &http2Error{code: http.StatusMethodNotAllowed, Reason: "MethodNotAllowed"}
+	}
+	hexToken, ok := strings.CutPrefix(r.URL.Path, "/3/device/")
+	if !ok {
+		return nil, &http2Error{code: http.StatusNotFound, Reason: "BadPath"}
+	}
+	n := &apns.Notification{Command: format.NotificationCMD}
+	if hexToken == "" {
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "MissingDeviceToken"}
+	}
+	b, err := hex.DecodeString(hexToken)
+	if err != nil || len(b) != format.TokenSize {
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "BadDeviceToken"}
+	}
+	copy(n.Token[:], b)
+
+	if !apnsIDPattern.MatchString(apnsID) {
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "BadMessageId"}
+	}
+	identifier, _ := strconv.ParseUint(apnsID[len(apnsID)-8:], 16, 32)
+	n.Identifier = int32(identifier)
+
+	if s := r.Header.Get("apns-expiration"); s != "" {
+		expiry, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, &http2Error{code: http.StatusBadRequest, Reason: "BadExpirationDate"}
+		}
+		n.Expiry = int32(expiry)
+	}
+	switch s := r.Header.Get("apns-priority"); s {
+	case "":
+	case "1", "5", "10":
+		priority, _ := strconv.Atoi(s)
+		n.Priority = int8(priority)
+	default:
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "BadPriority"}
+	}
+	if len(r.Header.Get("apns-collapse-id")) > 64 {
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "BadCollapseId"}
+	}
+	if err := checkHTTP2Topic(r); err != nil {
+		return nil, err
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, http2PayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(payload) == 0:
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "PayloadEmpty"}
+	case len(payload) > http2PayloadSize:
+		return nil, &http2Error{code: http.StatusRequestEntityTooLarge, Reason: "PayloadTooLarge"}
+	case !json.Valid(payload):
+		return nil, &http2Error{code: http.StatusBadRequest, Reason: "BadPayload"}
+	}
+	n.RawPayload = payload
+	return n, nil
+}
+
+// checkHTTP2Topic checks the apns-topic of a request against the client
+// certificate, which defaults it, and against -topic.
+func checkHTTP2Topic(r *http.Request) error {
+	topic := r.Header.Get("apns-topic")
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		topics := certTopics(r.TLS.PeerCertificates[0])
+		if topic == "" && len(topics) > 0 {
+			topic = topics[0]
+		}
+		allowed := false
+		for _, t := range topics {
+			allowed = allowed || t == topic
+		}
+		if len(topics) > 0 && !allowed {
+			return &http2Error{code: http.StatusBadRequest, Reason: "TopicDisallowed"}
+		}
+	}
+	if authOptions.topic != "" && topic != authOptions.topic {
+		if topic == "" {
+			return &http2Error{code: http.StatusBadRequest, Reason: "MissingTopic"}
+		}
+		return &http2Error{code: http.StatusBadRequest, Reason: "DeviceTokenNotForTopic"}
+	}
+	return nil
+}
+
+// http2Mock decides the fate of a notification the way the gateway does
+// for one it receives. A dropped connection aborts the stream.
+func http2Mock(rng *rand.Rand, opts *liveOptions, n *apns.Notification, log *slog.Logger) error {
+	found, drop, err := applyRule(opts.rules, n)
+	if drop {
+		log.Info("Dropping stream by rule")
+		panic(http.ErrAbortHandler)
+	}
+	if found {
+		return err
+	}
+	if err := checkRegistered(opts.tokens, n); err != nil {
+		return &http2Error{code: http.StatusGone, Reason: "Unregistered", Timestamp: time.Now().UnixMilli()}
+	}
+	if mockDrop(rng, &opts.mockErr) {
+		log.Info("Dropping stream")
+		panic(http.ErrAbortHandler)
+	}
+	return mockErr(rng, &opts.mockErr, n)
+}
+
+// http2ErrorFor returns the HTTP/2 response to a binary error response.
+func http2ErrorFor(resp *apns.ErrorResponse) *http2Error {
+	if resp != nil {
+		if r, ok := http2Reasons[resp.Status]; ok {
+			return &http2Error{code: r.code, Reason: r.reason}
+		}
+	}
+	return &http2Error{code: http.StatusInternalServerError, Reason: "InternalServerError"}
+}
+
+// newAPNsID returns a random apns-id, for requests without one.
+func newAPNsID(rng *rand.Rand) string {
+	b := make([]byte, 16)
+	rng.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// httpRemoteAddr returns the address of the client of a request.
+func httpRemoteAddr(r *http.Request) net.Addr {
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return net.TCPAddrFromAddrPort(ap)
+	}
+	return &net.TCPAddr{}
+}
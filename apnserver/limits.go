@@ -0,0 +1,64 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// activeConns counts the connections being handled, for -max-conns.
+var activeConns int32
+
+// acquireConn reserves a connection slot, reporting false if there are
+// already max connections. A max of 0 means no limit.
+func acquireConn(max int) bool {
+	if n := atomic.AddInt32(&activeConns, 1); max > 0 && int(n) > max {
+		atomic.AddInt32(&activeConns, -1)
+		return false
+	}
+	return true
+}
+
+// releaseConn frees a slot reserved by acquireConn.
+func releaseConn() {
+	atomic.AddInt32(&activeConns, -1)
+}
+
+// rateLimiter limits the notifications accepted on a connection to a
+// number per second, counted in one second windows.
+type rateLimiter struct {
+	rate  int
+	start time.Time
+	count int
+}
+
+// allow reports whether a notification arriving at the given time is
+// within the rate. A rate of 0 allows everything.
+func (l *rateLimiter) allow(at time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	if at.Sub(l.start) >= time.Second {
+		l.start = at
+		l.count = 0
+	}
+	l.count++
+	return l.count <= l.rate
+}
+
+// rateLimited returns the error response for a notification over the
+// rate limit. APNs gives no reason for such failures, so the mock uses
+// the retryable ProcessingErrorsStatus.
+func rateLimited(p apns.Packet) error {
+	return &apns.ErrorResponse{
+		Status:     format.ProcessingErrorsStatus,
+		Identifier: apnstest.Identifier(p),
+	}
+}
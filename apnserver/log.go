@@ -0,0 +1,97 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// LogOptions contains options for the server log.
+type LogOptions struct {
+	level slog.Level
+
+	// Either "text" or "json".
+	format string
+}
+
+// logger is the server log. Each connection logs through a child which
+// adds the connection's ID, see connLogger.
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// logLevel is the least level logged, which can change while the server
+// runs when the config file is reloaded.
+var logLevel = new(slog.LevelVar)
+
+// newLogger creates the server log according to the options.
+func newLogger(logOpts *LogOptions, verbose bool) (*slog.Logger, error) {
+	setLogLevel(logOpts, verbose)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	switch logOpts.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", logOpts.format)
+}
+
+// setLogLevel sets the least level logged. Verbose output is logging at
+// the debug level.
+func setLogLevel(logOpts *LogOptions, verbose bool) {
+	level := logOpts.level
+	if verbose && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	logLevel.Set(level)
+}
+
+// nextConnID numbers the connections to the server, so that the lines
+// logged and recorded for each can be told apart.
+var nextConnID uint64
+
+// newConnID returns the ID of a new connection.
+func newConnID() uint64 {
+	return atomic.AddUint64(&nextConnID, 1)
+}
+
+// connLogger returns the log for a connection of the given service.
+func connLogger(service string, id uint64, remote string) *slog.Logger {
+	return logger.With(
+		slog.String("service", service),
+		slog.Uint64("conn", id),
+		slog.String("remote", remote),
+	)
+}
+
+// packetAttr describes a packet for the log. The JSON log gets the packet
+// as a group of attributes, the text log gets it printed in the style set
+// by -packet-format.
+func packetAttr(p apns.Packet) slog.Attr {
+	if logOptions.format == "json" {
+		return slog.Any("packet", p)
+	}
+	return slog.String("packet", fmt.Sprint(p))
+}
+
+// tokenAttr describes a device token for the log, in full only if
+// -full-tokens is set.
+func tokenAttr(token format.Token) slog.Attr {
+	if format.LogFullTokens {
+		return slog.String("token", token.Hex())
+	}
+	return slog.String("token", token.Redacted())
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, err error) {
+	logger.Error(msg, "err", err)
+	os.Exit(1)
+}
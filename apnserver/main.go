@@ -6,15 +6,20 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/rand"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
 )
 
 // AuthOptions contains options related to authenticating an APNs connection.
@@ -22,17 +27,69 @@ type AuthOptions struct {
 	cerFile string
 	keyFile string
 	pemFile string
+
+	// Whether to generate a certificate for the SANs instead, and the file
+	// to write it to for clients to trust.
+	selfSigned     bool
+	selfSignedSANs string
+	selfSignedOut  string
+
+	// CA bundle for verifying client certificates.
+	caFile string
+
+	// Whether clients must present a certificate.
+	requireClientCert bool
+
+	// Topic client certificates must be issued for.
+	topic string
 }
 
 // ConnOptions contains options related to setting up and authenticating APNs 
 // connections.
 type ConnOptions struct {
 	port int
+
+	// Path of a Unix domain socket to listen on instead of a TCP port.
+	unixSocket string
+
+	// File of rules fixing the behavior for particular tokens.
+	rulesFile string
+
+	// File of the only tokens to accept.
+	tokensFile string
+
+	// How long after its arrival each notification is acted on, plus a
+	// random amount up to latencyJitter.
+	latency       time.Duration
+	latencyJitter time.Duration
+
+	// Most bytes per second read from each connection, or 0 for no limit.
+	throttle int
+
+	// How long after connecting each client is sent the Shutdown status,
+	// as during APNs maintenance, or 0 never to.
+	shutdownAfter time.Duration
+
+	// SQLite database to store the notifications received in.
+	dbFile string
+
+	// File to record the frames received to, and file of frames to
+	// replay instead of listening.
+	recordFile string
+	replayFile string
+
+	// Most connections handled at once, and notifications accepted per
+	// second on each, or 0 for no limit.
+	maxConns int
+	rate     int
 }
 
 // CMDOptions contains options which are used throughout this command.
 type CMDOptions struct {
 	verbose bool
+
+	// YAML file of options, reloaded on SIGHUP.
+	configFile string
 }
 
 // MockErrOptions contains options which determine how often a mocked error 
@@ -41,14 +98,32 @@ type CMDOptions struct {
 // notification.
 type MockErrOptions struct {
 	fail int
+
+	// The status codes of the mocked errors, with their relative weights.
+	statuses statusWeights
+
+	// How often the connection is closed without an error response, as
+	// APNs does for some protocol violations.
+	drop int
+
+	// How often error responses are mangled, see writeResponse.
+	chaos int
+
+	// Seed of the random choices, see connRand.
+	seed int64
 }
 
 // Command line options grouped by type.
 var (
-	authOptions    *AuthOptions
-	connOptions    *ConnOptions
-	cmdOptions     *CMDOptions
-	mockErrOptions *MockErrOptions
+	authOptions     *AuthOptions
+	connOptions     *ConnOptions
+	cmdOptions      *CMDOptions
+	mockErrOptions  *MockErrOptions
+	logOptions      *LogOptions
+	feedbackOptions *FeedbackOptions
+	adminOptions    *AdminOptions
+	http2Options    *HTTP2Options
+	relayOptions    *RelayOptions
 )
 
 func init() {
@@ -56,126 +131,537 @@ func init() {
 	flag.StringVar(&authOptions.keyFile, "key", "", "X.509 private key in pem (Privacy Enhanced Mail) format")
 	flag.StringVar(&authOptions.cerFile, "cer", "", "X.509 certificate in pem (Privacy Enhanced Mail) format")
 	flag.StringVar(&authOptions.pemFile, "pem", "", "X.509 certificate/key pair stored in a pem file")
+	flag.BoolVar(&authOptions.selfSigned, "self-signed", false, "Generate a self-signed certificate at startup instead of loading one")
+	flag.StringVar(&authOptions.selfSignedSANs, "san", "localhost,127.0.0.1", "Comma separated host names and IP addresses of the -self-signed certificate")
+	flag.StringVar(&authOptions.selfSignedOut, "self-signed-out", "", "File to write the -self-signed certificate to in pem format, for clients to trust")
+	flag.StringVar(&authOptions.caFile, "ca", "", "CA bundle in pem format to verify client certificates with")
+	flag.BoolVar(&authOptions.requireClientCert, "require-client-cert", false, "Refuse clients which do not present a certificate (verified against -ca, if given)")
+	flag.StringVar(&authOptions.topic, "topic", "", "Refuse clients whose certificate is not issued for this topic (bundle ID)")
 
 	cmdOptions = &CMDOptions{}
-	flag.BoolVar(&cmdOptions.verbose, "v", false, "Verbose output")
+	flag.BoolVar(&cmdOptions.verbose, "v", false, "Verbose output, the same as -log-level debug")
+	flag.StringVar(&cmdOptions.configFile, "config", "", "YAML file of options by flag name, and the port to listen on as port. Options on the command line take precedence. On SIGHUP the file is read again and the failure injection, latency, limits, logging, -rules and -tokens options are reapplied.")
+
+	logOptions = &LogOptions{}
+	flag.TextVar(&logOptions.level, "log-level", slog.LevelInfo, "Least level logged: debug, info, warn or error")
+	flag.StringVar(&logOptions.format, "log-format", "text", "Format of the log: text (key=value pairs) or json (one object per line)")
+	flag.TextVar(&format.StringStyle, "packet-format", format.JSONStyle, "How packets are printed with -v: json, compact (one line per packet) or verbose (compact with a hexdump)")
+	flag.BoolVar(&format.LogFullTokens, "full-tokens", false, "Print device tokens in full with -v instead of only their first and last 4 bytes")
+
+	feedbackOptions = &FeedbackOptions{}
+	flag.IntVar(&feedbackOptions.port, "feedback-port", 2196, "Port on which to simulate the feedback service, or 0 to disable it")
+	flag.StringVar(&feedbackOptions.file, "feedback", "", "File of feedback tuples to serve, one JSON object per line: {\"timestamp\": 1384392391, \"device-token\": \"<64 hex digits>\"}")
+
+	adminOptions = &AdminOptions{}
+	flag.IntVar(&adminOptions.port, "admin-port", 0, "Port of the admin HTTP endpoint, or 0 to disable it. GET /events streams received notifications as server-sent events, GET /status describes the server, POST /tokens and DELETE /tokens/<token> register and unregister device tokens.")
+
+	http2Options = &HTTP2Options{}
+	flag.IntVar(&http2Options.port, "http2-port", 0, "Port on which to also mock the HTTP/2 provider API (POST /3/device/<token>), or 0 to disable it. It uses TLS when the gateway does, and otherwise HTTP/2 without TLS (h2c).")
+
+	relayOptions = &RelayOptions{}
+	flag.StringVar(&relayOptions.target, "relay", "", "Forward notifications to a gateway instead of mocking responses: sandbox, production or host:port. Notifications are still logged, streamed and recorded.")
+	flag.StringVar(&relayOptions.auth.pemFile, "relay-pem", "", "X.509 certificate/key pair in a pem file to connect to the -relay gateway with")
+	flag.StringVar(&relayOptions.auth.cerFile, "relay-cer", "", "X.509 certificate in pem format to connect to the -relay gateway with")
+	flag.StringVar(&relayOptions.auth.keyFile, "relay-key", "", "X.509 private key in pem format to connect to the -relay gateway with")
 
 	mockErrOptions = &MockErrOptions{}
 	flag.IntVar(&mockErrOptions.fail, "fail", 0, "Determines how often the server should respond with an error. Accepted values are integers from 0 to 100, 100 causing all notifications to fail.")
+	mockErrOptions.statuses = statusWeights{{format.InvalidTokenStatus, 1}}
+	flag.Var(&mockErrOptions.statuses, "fail-status", "Comma separated status codes to respond with when failing a notification, each optionally followed by a relative weight, for example 8:50,7:30,10:20.")
+	flag.IntVar(&mockErrOptions.drop, "drop", 0, "Determines how often the server should close the connection after a notification without sending an error response. Accepted values are integers from 0 to 100, 100 dropping the connection at the first notification.")
+	flag.IntVar(&mockErrOptions.chaos, "chaos", 0, "Determines how often an error response is truncated, replaced with garbage or written in pieces split mid-field, to test client parsers against a broken peer. Accepted values are integers from 0 to 100.")
+	flag.Int64Var(&mockErrOptions.seed, "seed", 0, "Seed of the random mock errors, drops and latency jitter, or 0 for a random one. The seed is logged at startup; running again with it fails the same notifications, provided clients connect in the same order and send the same notifications.")
+
+	connOptions = &ConnOptions{}
+	flag.DurationVar(&connOptions.latency, "latency", 0, "How long to wait after receiving a notification before acting on it, delaying any error response by as much, for example 80ms")
+	flag.DurationVar(&connOptions.latencyJitter, "latency-jitter", 0, "Random extra latency of up to this duration, added to -latency for each notification")
+	flag.StringVar(&connOptions.dbFile, "db", "", "SQLite database to store every notification received in, queried with GET /notifications on the admin endpoint. Needs apnserver built with -tags sqlite.")
+	flag.StringVar(&connOptions.recordFile, "record", "", "File to record every frame received to, with its connection and time, one JSON object per line")
+	flag.StringVar(&connOptions.replayFile, "replay", "", "File of frames recorded with -record to feed back through the server instead of listening. The responses are logged.")
+	flag.DurationVar(&connOptions.shutdownAfter, "shutdown-after", 0, "Send each client the Shutdown status (10) with the identifier of the last notification accepted this long after it connects, then close the connection, as APNs does during maintenance")
+	flag.IntVar(&connOptions.maxConns, "max-conns", 0, "Most clients connected at once, or 0 for no limit. Further connections are closed as soon as they are accepted.")
+	flag.IntVar(&connOptions.rate, "rate", 0, "Most notifications per second accepted on each connection, or 0 for no limit. Notifications over the rate fail with the ProcessingError status (1).")
+	flag.IntVar(&connOptions.throttle, "throttle", 0, "Most bytes per second to read from each connection, or 0 for no limit")
+	flag.StringVar(&connOptions.tokensFile, "tokens", "", "File of registered device tokens, one per line. Once any are registered, here or through the admin endpoint, notifications to any other token fail with the InvalidToken status (8) and the token is reported by the feedback service.")
+	flag.StringVar(&connOptions.rulesFile, "rules", "", "File of per-token rules, one JSON object per line: {\"device-token\": \"<64 hex digits>\", \"behavior\": \"succeed|status|drop\", \"status\": 8, \"delay-ms\": 250}")
 
 	flag.Usage = func() {
-		fmt.Println("apnserver - Push notification dummy server for Apple Push Notification system (APNs).\n")
-		fmt.Fprintf(os.Stderr, "Usage: apnserver [OPTIONS] port\n")
+		fmt.Print("apnserver - Push notification dummy server for Apple Push Notification system (APNs).\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: apnserver [OPTIONS] port|unix:///path/to/socket\n")
 		flag.PrintDefaults()
 		fmt.Println("\nTo convert a pkcs#12 (.p12) certificate+key pair to pem, use opensll:")
 		fmt.Println("\topenssl pkcs12 -in CertificateName.p12 -out CertificateName.pem -nodes")
 	}
+}
 
+// parseFlags parses the command line, and the -config file if any, into
+// the options. It is not done in init so the package's tests can run.
+func parseFlags() {
 	flag.Parse()
 
-	connOptions = &ConnOptions{}
+	listenAddr := flag.Arg(0)
+	if cmdOptions.configFile != "" {
+		values, err := readConfig(cmdOptions.configFile)
+		if err == nil {
+			_, err = applyConfig(values, commandLineFlags())
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		loadedConfig = values
+		if listenAddr == "" {
+			listenAddr = values["port"]
+		}
+	}
 
-	if flag.NArg() == 0 {
+	if listenAddr == "" {
 		connOptions.port = 2195
+	} else if strings.HasPrefix(listenAddr, "unix://") {
+		connOptions.unixSocket = strings.TrimPrefix(listenAddr, "unix://")
 	} else {
-		port, err := strconv.Atoi(flag.Arg(0))
+		port, err := strconv.Atoi(listenAddr)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 		connOptions.port = port
 	}
+
+	var err error
+	if logger, err = newLogger(logOptions, cmdOptions.verbose); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 func main() {
-	rand.Seed(time.Now().UTC().UnixNano())
+	parseFlags()
+	if mockErrOptions.seed == 0 {
+		mockErrOptions.seed = time.Now().UTC().UnixNano()
+	}
+	logger.Info("Random seed", "seed", mockErrOptions.seed)
+
 	cert, err := certificate(authOptions)
 	if err != nil {
-		fmt.Printf("Error loading certificate+key pair. %s\n", err)
-		os.Exit(1)
+		fatal("Error loading certificate+key pair", err)
 	}
 
 	if cert == nil {
-		verbosePrintf("No certificate+key pair provided, using unauthenticated connection.\n")
+		logger.Debug("No certificate+key pair provided, using unauthenticated connection")
+	} else if authOptions.selfSigned && authOptions.selfSignedOut != "" {
+		logger.Info("Wrote the self-signed certificate", "san", authOptions.selfSignedSANs, "file", authOptions.selfSignedOut)
 	} else {
-		verbosePrintf("Note: you may need to install the root certificate on the client machine.\n")
+		logger.Debug("Note: you may need to install the root certificate on the client machine")
+	}
+
+	config, err := tlsConfig(cert, authOptions)
+	if err != nil {
+		fatal("Error configuring TLS", err)
+	}
+
+	if err := checkMockErrOptions(mockErrOptions); err != nil {
+		fatal("Invalid mock error options", err)
 	}
 
 	if mockErrOptions.fail == 0 {
-		verbosePrintf("No mock errors will be used.\n")
-	} else if mockErrOptions.fail > 100 {
-		fmt.Printf("%d is an invalid value for --fail", mockErrOptions.fail)
-		os.Exit(1)
+		logger.Debug("No mock errors will be used")
 	} else {
-		verbosePrintf("Mock errors configured to %d%%.\n", mockErrOptions.fail)
+		logger.Debug("Mock errors configured", "percent", mockErrOptions.fail, "statuses", mockErrOptions.statuses.String())
+	}
+
+	if mockErrOptions.drop != 0 {
+		logger.Debug("Silent connection drops configured", "percent", mockErrOptions.drop)
 	}
 
-	conn, err := listen(cert, connOptions.port)
+	var rules map[format.Token]tokenRule
+	if connOptions.rulesFile != "" {
+		rules, err = loadRules(connOptions.rulesFile)
+		if err != nil {
+			fatal("Error loading rules", err)
+		}
+		logger.Debug("Loaded rules", "tokens", len(rules))
+	}
+
+	tokens := newTokenSet()
+	if connOptions.tokensFile != "" {
+		tokens, err = loadTokens(connOptions.tokensFile)
+		if err != nil {
+			fatal("Error loading tokens", err)
+		}
+		n, _ := tokens.len()
+		logger.Debug("Accepting registered tokens", "tokens", n)
+	}
+	publishOptions(rules, tokens)
+
+	if relayOptions.target != "" {
+		relayOptions.cert, err = certificate(&relayOptions.auth)
+		if err != nil {
+			fatal("Error loading relay certificate+key pair", err)
+		}
+		logger.Info("Relaying notifications", "gateway", relayOptions.target)
+	}
+
+	if connOptions.replayFile != "" {
+		if err := replay(connOptions.replayFile); err != nil {
+			fatal("Error replaying frames", err)
+		}
+		return
+	}
+
+	if connOptions.dbFile != "" {
+		notificationStore, err = openStore(connOptions.dbFile)
+		if err != nil {
+			fatal("Error opening database", err)
+		}
+		logger.Info("Storing notifications", "db", connOptions.dbFile)
+	}
+
+	if connOptions.recordFile != "" {
+		frameRecorder, err = newRecorder(connOptions.recordFile)
+		if err != nil {
+			fatal("Error creating record file", err)
+		}
+		logger.Info("Recording frames", "file", connOptions.recordFile)
+	}
+
+	if cmdOptions.configFile != "" {
+		go reloadOnHangup(cmdOptions.configFile, commandLineFlags())
+	}
+
+	conn, err := listen(config, connOptions)
 	if err != nil {
-		fmt.Printf("Error starting TCP connection. %s\n", err)
-		os.Exit(1)
+		fatal("Error starting TCP connection", err)
+	}
+
+	if feedbackOptions.port != 0 {
+		err = serveFeedback(config, feedbackOptions)
+		if err != nil {
+			fatal("Error starting feedback service", err)
+		}
+	}
+
+	if http2Options.port != 0 {
+		err = serveHTTP2(config, http2Options)
+		if err != nil {
+			fatal("Error starting HTTP/2 provider API", err)
+		}
+	}
+
+	if adminOptions.port != 0 {
+		err = serveAdmin(adminOptions)
+		if err != nil {
+			fatal("Error starting admin endpoint", err)
+		}
 	}
 
-	fmt.Printf("Listening on port %d\n", connOptions.port)
+	if connOptions.unixSocket != "" {
+		logger.Info("Listening", "socket", connOptions.unixSocket)
+	} else {
+		logger.Info("Listening", "port", connOptions.port)
+	}
 
 	for {
 		client, err := conn.Accept()
 		if err != nil {
-			fmt.Printf("Unexpected error while accepting connection. %s\n", err)
-			os.Exit(1)
+			fatal("Unexpected error while accepting connection", err)
+		}
+		id := newConnID()
+		log := connLogger("gateway", id, client.RemoteAddr().String())
+		if maxConns := currentOptions().conn.maxConns; !acquireConn(maxConns) {
+			log.Warn("Refused connection over the limit", "max-conns", maxConns)
+			client.Close()
+			continue
+		}
+		log.Debug("Connected")
+		go func() {
+			defer releaseConn()
+			handleClient(client, id, log)
+		}()
+	}
+}
+
+// arrival is a packet read from a client, or the error reading it, with
+// the time it arrived.
+type arrival struct {
+	p    apns.Packet
+	err  error
+	at   time.Time
+	data []byte
+}
+
+// readPackets reads packets from a connection until it fails or done is
+// closed, so that they can be handled after the simulated latency without
+// holding up reading.
+func readPackets(conn net.Conn, id uint64, packets chan<- arrival, done <-chan struct{}) {
+	defer close(packets)
+	var r io.Reader = conn
+	if throttle := currentOptions().conn.throttle; throttle > 0 {
+		r = &throttledReader{r: conn, rate: throttle}
+	}
+	var capture *captureReader
+	if frameRecorder != nil || relayOptions.target != "" {
+		capture = &captureReader{r: r}
+		r = capture
+	}
+	for {
+		p, err := apns.ReadCommand(r)
+		var data []byte
+		if capture != nil {
+			data = capture.take()
+			if frameRecorder != nil && len(data) > 0 {
+				frameRecorder.record(recordedFrame{Conn: id, Time: time.Now(), Data: data})
+			}
+		}
+		select {
+		case packets <- arrival{p, err, time.Now(), data}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
 		}
-		verbosePrintf("[%v] Connected: %v\n", time.Now(), client.RemoteAddr())
-		go handleClient(client, mockErrOptions)
 	}
 }
 
-// verbosePrintf will print to the console only if the corresponding line option 
-// is set.
-func verbosePrintf(format string, a ...interface{}) (n int, err error) {
-	if cmdOptions.verbose {
-		return fmt.Printf(format, a...)
+// throttledReader limits the rate of reads, in bytes per second. Reads
+// are kept small so that the rate holds over short periods too.
+type throttledReader struct {
+	r    io.Reader
+	rate int
+	next time.Time
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if max := t.rate/10 + 1; len(p) > max {
+		p = p[:max]
 	}
-	return 0, nil
+	n, err := t.r.Read(p)
+	if now := time.Now(); t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(n) * time.Second / time.Duration(t.rate))
+	time.Sleep(time.Until(t.next))
+	return n, err
+}
+
+// latency returns the simulated latency for a notification.
+func latency(rng *rand.Rand, connOpts *ConnOptions) time.Duration {
+	d := connOpts.latency
+	if connOpts.latencyJitter > 0 {
+		d += time.Duration(rng.Int63n(int64(connOpts.latencyJitter)))
+	}
+	return d
 }
 
-// handleClient reads messages from a TCP connection.
-func handleClient(conn net.Conn, mockErrOpts *MockErrOptions) {
+// handleClient reads messages from a TCP connection. The options in effect
+// are loaded for each notification, except -throttle and -shutdown-after,
+// which are fixed when the client connects.
+func handleClient(conn net.Conn, id uint64, log *slog.Logger) {
 	defer conn.Close()
+	defer log.Debug("Disconnected")
+	if err := verifyClient(conn, log, authOptions); err != nil {
+		log.Warn("Refused client", "err", err)
+		return
+	}
+	var gateway net.Conn
+	relayed := make(chan struct{})
+	if relayOptions.target != "" {
+		var err error
+		if gateway, err = dialRelay(relayOptions); err != nil {
+			log.Warn("Error connecting to the relay gateway", "err", err)
+			return
+		}
+		defer gateway.Close()
+		go func() {
+			defer close(relayed)
+			relayResponses(gateway, conn, log)
+		}()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	packets := make(chan arrival, 1024)
+	go readPackets(conn, id, packets, done)
+	opts := currentOptions()
+	limiter := &rateLimiter{}
+	rng := connRand(&opts.mockErr, id)
+	var shutdown <-chan time.Time
+	if opts.conn.shutdownAfter > 0 {
+		timer := time.NewTimer(opts.conn.shutdownAfter)
+		defer timer.Stop()
+		shutdown = timer.C
+	}
+	// The identifier of the last notification accepted, reported when
+	// shutting down.
+	var lastID int32
 	for {
-		n, err := apns.ReadCommand(conn)
+		var a arrival
+		select {
+		case next, ok := <-packets:
+			if !ok {
+				return
+			}
+			a = next
+		case <-shutdown:
+			resp := &apns.ErrorResponse{Status: format.ShutdownStatus, Identifier: lastID}
+			log.Info("Shutting down connection", packetAttr(resp))
+			if err := writeResponse(conn, resp, rng, &opts.mockErr, log); err != nil {
+				log.Warn("Error writing response", "err", err)
+			}
+			return
+		}
+		opts = currentOptions()
+		time.Sleep(time.Until(a.at.Add(latency(rng, &opts.conn))))
+		n, err := a.p, a.err
+		if err == nil {
+			log.Debug("Received", packetAttr(n))
+			if pn, ok := n.(apns.PushNotification); ok {
+				publishPush(conn.RemoteAddr(), pn)
+				if notificationStore != nil {
+					notificationStore.save(conn.RemoteAddr(), pn)
+				}
+			}
+		}
+		if gateway != nil {
+			// The gateway decides the response; forward the packet as
+			// received, even if it could not be decoded.
+			if _, werr := gateway.Write(a.data); werr != nil {
+				log.Warn("Error relaying to the gateway", "err", werr)
+				return
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Info("Invalid packet", "err", err)
+				}
+				finishRelay(gateway, relayed)
+				return
+			}
+			continue
+		}
+		var perr *apns.ParseError
+		if errors.As(err, &perr) {
+			// The rest of the stream can't be trusted, so hang up after
+			// responding, if APNs would respond at all.
+			log.Info("Invalid packet", "err", err)
+			if resp := apnstest.ParseErrorResponse(perr); resp != nil {
+				writeResponse(conn, resp, rng, &opts.mockErr, log)
+			}
+			return
+		}
+		limiter.rate = opts.conn.rate
+		if err == nil && !limiter.allow(a.at) {
+			err = rateLimited(n)
+		}
+		if err == nil {
+			if resp := apnstest.Validate(n); resp != nil {
+				err = resp
+			}
+		}
+		if err == nil {
+			found, drop, ruleErr := applyRule(opts.rules, n)
+			if drop {
+				log.Info("Dropping connection by rule")
+				return
+			}
+			if found && ruleErr == nil {
+				lastID = apnstest.Identifier(n)
+				continue
+			}
+			err = ruleErr
+		}
 		if err == nil {
-			verbosePrintf("Received: %s\n", n)
+			err = checkRegistered(opts.tokens, n)
+		}
+		if err == nil && mockDrop(rng, &opts.mockErr) {
+			log.Info("Dropping connection")
+			return
 		}
 		if err == nil {
-			err = mockErr(mockErrOpts, n)
+			err = mockErr(rng, &opts.mockErr, n)
 		}
 		if err == nil {
+			lastID = apnstest.Identifier(n)
 			continue
 		}
-		// If the error is an ErrorResponse then write it to the stream.
+		// If the error is an ErrorResponse then write it to the stream, and
+		// like APNs discard whatever follows and hang up.
 		if resp, isResp := err.(*apns.ErrorResponse); isResp {
-			verbosePrintf("Responding: %s\n", resp)
-			err = resp.WriteTo(conn)
+			err = writeResponse(conn, resp, rng, &opts.mockErr, log)
 			if err != nil {
-				fmt.Println(err)
+				log.Warn("Error writing response", "err", err)
 			}
-			time.Sleep(1000 * time.Millisecond)
-			continue
+			discardAfterError(conn, packets, log)
+			return
 		}
 
-		verbosePrintf("%s\n", err)
+		if err != io.EOF {
+			log.Debug("Closing connection", "err", err)
+		}
 		return
 	}
 }
 
+// discardAfterError reports the notifications received after one was
+// rejected, which APNs discards, until the client hangs up or a second
+// has passed. The connection is closed for writing first, so that the
+// client sees the end of it after the error response.
+func discardAfterError(conn net.Conn, packets <-chan arrival, log *slog.Logger) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	timeout := time.After(time.Second)
+	discarded := 0
+	defer func() {
+		if discarded > 0 {
+			log.Info("Discarded notifications after error", "count", discarded)
+		}
+	}()
+	for {
+		select {
+		case a, ok := <-packets:
+			if !ok || a.err != nil {
+				return
+			}
+			if _, isPN := a.p.(apns.PushNotification); isPN {
+				log.Debug("Discarded", packetAttr(a.p))
+				discarded++
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// checkMockErrOptions checks that the mock error percentages are at most
+// 100.
+func checkMockErrOptions(mockErrOpts *MockErrOptions) error {
+	if mockErrOpts.fail > 100 {
+		return fmt.Errorf("-fail %d is over 100", mockErrOpts.fail)
+	}
+	if mockErrOpts.drop > 100 {
+		return fmt.Errorf("-drop %d is over 100", mockErrOpts.drop)
+	}
+	if mockErrOpts.chaos > 100 {
+		return fmt.Errorf("-chaos %d is over 100", mockErrOpts.chaos)
+	}
+	return nil
+}
+
 // mockErr will randomly return an error to simulate notification failures.
-func mockErr(mockErrOpts *MockErrOptions, n apns.Packet) error {
-	i := rand.Intn(101-1) + 1
+// Enhanced and command 2 notifications are answered with an error response;
+// simple notifications, which have no identifier to report, just lose the
+// connection.
+func mockErr(rng *rand.Rand, mockErrOpts *MockErrOptions, n apns.Packet) error {
+	i := rng.Intn(101-1) + 1
 	if i < mockErrOpts.fail {
-		if en, isEN := n.(*apns.EnhancedNotification); isEN {
+		switch n.(type) {
+		case *apns.EnhancedNotification, *apns.Notification:
 			resp := &apns.ErrorResponse{
-				Status:     apns.InvalidTokenStatus,
-				Identifier: en.Identifier,
+				Status:     mockErrOpts.statuses.pick(rng),
+				Identifier: apnstest.Identifier(n),
+			}
+			if token, _ := apnstest.Token(n); resp.Status.ShouldDropToken() {
+				failedTokens.add(token)
 			}
 			return resp
 		}
@@ -184,6 +670,78 @@ func mockErr(mockErrOpts *MockErrOptions, n apns.Packet) error {
 	return nil
 }
 
+// statusWeight is a status code to mock and its relative weight.
+type statusWeight struct {
+	status format.Status
+	weight int
+}
+
+// statusWeights is a flag.Value for a list of status codes with weights,
+// written as "8:50,7:30,10:20". A weight left out counts as 1.
+type statusWeights []statusWeight
+
+func (sw *statusWeights) String() string {
+	if sw == nil {
+		return ""
+	}
+	parts := make([]string, len(*sw))
+	for i, w := range *sw {
+		parts[i] = fmt.Sprintf("%d:%d", w.status, w.weight)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (sw *statusWeights) Set(value string) error {
+	var list statusWeights
+	for _, part := range strings.Split(value, ",") {
+		code, weight, hasWeight := strings.Cut(strings.TrimSpace(part), ":")
+		status, err := strconv.ParseUint(code, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid status code %q", code)
+		}
+		w := 1
+		if hasWeight {
+			w, err = strconv.Atoi(weight)
+			if err != nil || w < 1 {
+				return fmt.Errorf("invalid weight %q for status %d", weight, status)
+			}
+		}
+		list = append(list, statusWeight{format.Status(status), w})
+	}
+	*sw = list
+	return nil
+}
+
+// pick chooses a status code at random according to the weights.
+func (sw statusWeights) pick(rng *rand.Rand) format.Status {
+	total := 0
+	for _, w := range sw {
+		total += w.weight
+	}
+	i := rng.Intn(total)
+	for _, w := range sw {
+		if i < w.weight {
+			return w.status
+		}
+		i -= w.weight
+	}
+	return format.UnknownStatus
+}
+
+// mockDrop randomly decides to close a connection without an error
+// response, to simulate APNs hanging up on a client.
+func mockDrop(rng *rand.Rand, mockErrOpts *MockErrOptions) bool {
+	return rng.Intn(100) < mockErrOpts.drop
+}
+
+// connRand returns the source of the random choices made for a connection.
+// Each connection has its own, seeded from -seed and the connection's ID,
+// so that a run is reproduced by the same seed however the connections
+// interleave.
+func connRand(mockErrOpts *MockErrOptions, id uint64) *rand.Rand {
+	return rand.New(rand.NewSource(mockErrOpts.seed + int64(id)))
+}
+
 // certificate creates an x.509 certificate based on the supplied options.
 func certificate(authOpts *AuthOptions) (cert *tls.Certificate, err error) {
 	var c tls.Certificate
@@ -202,6 +760,9 @@ func certificate(authOpts *AuthOptions) (cert *tls.Certificate, err error) {
 		}
 		cert = &c
 
+	case authOpts.selfSigned:
+		cert, err = selfSigned(authOpts.selfSignedSANs, authOpts.selfSignedOut)
+
 	default:
 		cert, err = nil, nil
 	}
@@ -209,15 +770,21 @@ func certificate(authOpts *AuthOptions) (cert *tls.Certificate, err error) {
 }
 
 // Listen will create a TCP connection and listen for incoming
-// clients. 
-func listen(cer *tls.Certificate, port int) (conn net.Listener, err error) {
+// clients. A Unix domain socket is listened on instead if one is
+// configured; such connections are local and never use TLS.
+func listen(config *tls.Config, connOpts *ConnOptions) (conn net.Listener, err error) {
+	if connOpts.unixSocket != "" {
+		return net.Listen("unix", connOpts.unixSocket)
+	}
+	return listenPort(config, connOpts.port)
+}
+
+// listenPort will listen for incoming clients on a TCP port, using TLS if
+// a configuration is given.
+func listenPort(config *tls.Config, port int) (conn net.Listener, err error) {
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 
-	if cer != nil {
-		config := &tls.Config{
-			Certificates:       []tls.Certificate{*cer},
-			InsecureSkipVerify: true,
-		}
+	if config != nil {
 		conn, err = tls.Listen("tcp", addr, config)
 	} else {
 		taddr, _ := net.ResolveTCPAddr("tcp", addr)
@@ -0,0 +1,193 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cfilipov/apns"
+)
+
+// recordedFrame is the raw data of a packet received on a connection,
+// stored one JSON object per line by -record:
+//
+// 		{"conn": 3, "time": "2013-11-13T18:46:31.05Z", "data": "<base64>"}
+//
+// A packet which could not be decoded is recorded with the bytes read
+// before the error, so that replaying it fails the same way.
+type recordedFrame struct {
+	Conn uint64    `json:"conn"`
+	Time time.Time `json:"time"`
+	Data []byte    `json:"data"`
+}
+
+// recorder appends the frames received on every connection to a file.
+type recorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// frameRecorder is the recorder for -record, or nil if not recording.
+var frameRecorder *recorder
+
+// newRecorder creates the file to record frames to.
+func newRecorder(file string) (*recorder, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &recorder{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// record writes a frame and flushes it, so that the file is complete
+// whenever the server is stopped.
+func (r *recorder) record(frame recordedFrame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(frame); err != nil {
+		logger.Warn("Error recording frame", "err", err)
+		return
+	}
+	if err := r.w.Flush(); err != nil {
+		logger.Warn("Error recording frame", "err", err)
+	}
+}
+
+// captureReader keeps the bytes read through it until taken.
+type captureReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
+
+// take returns the bytes read since the last call.
+func (c *captureReader) take() []byte {
+	data := append([]byte(nil), c.buf.Bytes()...)
+	c.buf.Reset()
+	return data
+}
+
+// replay feeds the connections recorded in a file back through
+// handleClient, each starting at the same time relative to the first as
+// when recorded, and logs the responses. It returns once every
+// connection has been handled.
+func replay(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var sessions [][]recordedFrame
+	index := make(map[uint64]int)
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var frame recordedFrame
+		if err := dec.Decode(&frame); err != nil {
+			return err
+		}
+		i, ok := index[frame.Conn]
+		if !ok {
+			i = len(sessions)
+			index[frame.Conn] = i
+			sessions = append(sessions, nil)
+		}
+		sessions[i] = append(sessions[i], frame)
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+	logger.Info("Replaying", "file", file, "connections", len(sessions))
+
+	offset := time.Since(sessions[0][0].Time)
+	var wg sync.WaitGroup
+	for _, frames := range sessions {
+		wg.Add(1)
+		go func(frames []recordedFrame) {
+			defer wg.Done()
+			replaySession(frames, offset)
+		}(frames)
+	}
+	wg.Wait()
+	return nil
+}
+
+// replaySession writes the frames of one recorded connection at their
+// recorded times, shifted by offset, and logs the server's responses.
+func replaySession(frames []recordedFrame, offset time.Duration) {
+	id := frames[0].Conn
+	log := connLogger("replay", id, "replay")
+	client, server := replayPipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			p, err := apns.ReadCommand(client)
+			if err != nil {
+				return
+			}
+			log.Info("Response", packetAttr(p))
+		}
+	}()
+	go func() {
+		defer client.closeWrite()
+		for _, frame := range frames {
+			time.Sleep(time.Until(frame.Time.Add(offset)))
+			if _, err := client.Write(frame.Data); err != nil {
+				return
+			}
+		}
+	}()
+	handleClient(server, id, log)
+	<-done
+}
+
+// pipeConn is one end of an in-memory connection, which unlike net.Pipe
+// can be closed for writing only, so that the server sees the end of a
+// replayed connection but can still respond.
+type pipeConn struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+// replayPipe returns the two ends of an in-memory connection.
+func replayPipe() (client, server *pipeConn) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	return &pipeConn{cr, cw}, &pipeConn{sr, sw}
+}
+
+func (p *pipeConn) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *pipeConn) Write(b []byte) (int, error) { return p.w.Write(b) }
+func (p *pipeConn) closeWrite() error           { return p.w.Close() }
+
+func (p *pipeConn) Close() error {
+	p.r.Close()
+	return p.w.Close()
+}
+
+func (p *pipeConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (p *pipeConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (p *pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (p *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (p *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
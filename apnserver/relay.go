@@ -0,0 +1,70 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/cfilipov/apns"
+)
+
+// RelayOptions contains options for forwarding notifications to a real
+// gateway instead of mocking its responses.
+type RelayOptions struct {
+	// "sandbox", "production" or the host:port of a gateway.
+	target string
+
+	// The certificate to connect to the gateway with.
+	auth AuthOptions
+	cert *tls.Certificate
+}
+
+// dialRelay connects to the gateway notifications are relayed to.
+func dialRelay(relayOpts *RelayOptions) (net.Conn, error) {
+	switch relayOpts.target {
+	case "sandbox":
+		return apns.DialAPN(relayOpts.cert, apns.SANDBOX, false)
+	case "production":
+		return apns.DialAPN(relayOpts.cert, apns.DISTRIBUTION, false)
+	}
+	return apns.Dial(relayOpts.cert, relayOpts.target, false)
+}
+
+// finishRelay tells the gateway there is nothing more to send, and waits
+// a moment for it to respond and hang up.
+func finishRelay(gateway net.Conn, relayed <-chan struct{}) {
+	if cw, ok := gateway.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	select {
+	case <-relayed:
+	case <-time.After(time.Second):
+	}
+}
+
+// relayResponses passes the error responses of the gateway back to the
+// client. The gateway closes its connection after an error response, and
+// so the client's connection is closed too.
+func relayResponses(gateway, conn net.Conn, log *slog.Logger) {
+	defer conn.Close()
+	for {
+		p, err := apns.ReadCommand(gateway)
+		if err != nil {
+			if err != io.EOF {
+				log.Info("Gateway connection failed", "err", err)
+			}
+			return
+		}
+		log.Info("Gateway response", packetAttr(p))
+		if _, err := p.WriteTo(conn); err != nil {
+			log.Warn("Error writing response", "err", err)
+			return
+		}
+	}
+}
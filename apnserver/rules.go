@@ -0,0 +1,92 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// The behaviors a rule can give a token.
+const (
+	succeedBehavior = "succeed"
+	statusBehavior  = "status"
+	dropBehavior    = "drop"
+)
+
+// tokenRule fixes how the server treats notifications for one device
+// token, overriding the random failures of MockErrOptions. In the rules
+// file it is a JSON object such as:
+//
+// 		{"device-token": "<64 hex digits>", "behavior": "status", "status": 8, "delay-ms": 250}
+type tokenRule struct {
+	Token format.Token `json:"device-token"`
+
+	// "succeed" (the default), "status" to respond with Status, or "drop"
+	// to close the connection without a response.
+	Behavior string `json:"behavior"`
+
+	Status format.Status `json:"status"`
+
+	// How long to wait before acting on the notification.
+	DelayMS int `json:"delay-ms"`
+}
+
+// loadRules reads token rules from a file of JSON lines.
+func loadRules(file string) (map[format.Token]tokenRule, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[format.Token]tokenRule)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var rule tokenRule
+		if err := dec.Decode(&rule); err != nil {
+			return nil, err
+		}
+		switch rule.Behavior {
+		case "":
+			rule.Behavior = succeedBehavior
+		case succeedBehavior, statusBehavior, dropBehavior:
+		default:
+			return nil, fmt.Errorf("token %s: unknown behavior %q", rule.Token, rule.Behavior)
+		}
+		rules[rule.Token] = rule
+	}
+	return rules, nil
+}
+
+// applyRule looks up the rule for the token of a notification. It reports
+// whether there is one, and whether the connection should be dropped; an
+// error response to send is returned as the error.
+func applyRule(rules map[format.Token]tokenRule, p apns.Packet) (found, drop bool, err error) {
+	token, ok := apnstest.Token(p)
+	if !ok {
+		return false, false, nil
+	}
+	rule, ok := rules[token]
+	if !ok {
+		return false, false, nil
+	}
+	time.Sleep(time.Duration(rule.DelayMS) * time.Millisecond)
+	switch rule.Behavior {
+	case dropBehavior:
+		return true, true, nil
+	case statusBehavior:
+		if rule.Status.ShouldDropToken() {
+			failedTokens.add(token)
+		}
+		return true, false, &apns.ErrorResponse{Status: rule.Status, Identifier: apnstest.Identifier(p)}
+	}
+	return true, false, nil
+}
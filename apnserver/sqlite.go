@@ -0,0 +1,10 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build sqlite
+
+package main
+
+// The SQLite driver for -db needs cgo, so it is only built in on request.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,252 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// sqliteDriver is the database/sql driver used by -db. It is registered
+// only when apnserver is built with the sqlite tag (see sqlite.go), as the
+// driver needs cgo:
+//
+// 		go build -tags sqlite github.com/cfilipov/apns/apnserver
+const sqliteDriver = "sqlite3"
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS notifications (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	received_at  INTEGER NOT NULL,
+	remote       TEXT NOT NULL,
+	command      INTEGER NOT NULL,
+	token        TEXT NOT NULL,
+	identifier   INTEGER NOT NULL,
+	payload      TEXT NOT NULL,
+	notification TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS notifications_token ON notifications (token, received_at);
+CREATE INDEX IF NOT EXISTS notifications_received_at ON notifications (received_at);
+`
+
+// notificationStore persists every notification received, for -db. It
+// is nil if not storing.
+var notificationStore *store
+
+// store writes notifications to a SQLite database in the background, so
+// that clients are not held up by the disk.
+type store struct {
+	db      *sql.DB
+	pending chan storedNotification
+}
+
+// storedNotification is a row of the notifications table, and the JSON
+// returned for it by the query endpoint.
+type storedNotification struct {
+	ID           int64           `json:"id"`
+	Time         time.Time       `json:"time"`
+	Remote       string          `json:"remote"`
+	Notification json.RawMessage `json:"notification"`
+
+	command    format.Command
+	token      string
+	identifier int32
+	payload    string
+}
+
+// openStore opens or creates the database.
+func openStore(file string) (*store, error) {
+	found := false
+	for _, name := range sql.Drivers() {
+		found = found || name == sqliteDriver
+	}
+	if !found {
+		return nil, errors.New("apnserver was built without SQLite support, rebuild it with -tags sqlite")
+	}
+	db, err := sql.Open(sqliteDriver, file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &store{db: db, pending: make(chan storedNotification, 1024)}
+	go s.write()
+	return s, nil
+}
+
+// save queues a notification to be written. If the database can't keep
+// up the notification is dropped, rather than slowing down the server.
+func (s *store) save(remote net.Addr, pn apns.PushNotification) {
+	text := pn.Text(format.JSONStyle)
+	var fields struct {
+		Command format.Command  `json:"command"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	json.Unmarshal([]byte(text), &fields)
+	token, _ := apnstest.Token(pn)
+	row := storedNotification{
+		Time:         time.Now(),
+		Remote:       remote.String(),
+		Notification: json.RawMessage(text),
+		command:      fields.Command,
+		token:        token.Hex(),
+		identifier:   apnstest.Identifier(pn),
+		payload:      string(fields.Payload),
+	}
+	select {
+	case s.pending <- row:
+	default:
+		logger.Warn("Dropped notification, the database is not keeping up")
+	}
+}
+
+// write inserts the queued notifications, a transaction at a time.
+func (s *store) write() {
+	for row := range s.pending {
+		rows := []storedNotification{row}
+		for len(rows) < cap(s.pending) && len(s.pending) > 0 {
+			rows = append(rows, <-s.pending)
+		}
+		if err := s.insert(rows); err != nil {
+			logger.Warn("Error storing notifications", "err", err, "count", len(rows))
+		}
+	}
+}
+
+func (s *store) insert(rows []storedNotification) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO notifications
+		(received_at, remote, command, token, identifier, payload, notification)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		_, err := stmt.Exec(r.Time.UnixNano(), r.Remote, int(r.command), r.token, r.identifier, r.payload, string(r.Notification))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storeQuery selects stored notifications. Zero fields match everything.
+type storeQuery struct {
+	token   string
+	since   time.Time
+	until   time.Time
+	payload string
+	limit   int
+}
+
+// query returns the notifications matching q, oldest first.
+func (s *store) query(q storeQuery) ([]storedNotification, error) {
+	sqlText := `SELECT id, received_at, remote, notification FROM notifications WHERE 1=1`
+	var args []interface{}
+	if q.token != "" {
+		sqlText += ` AND token = ?`
+		args = append(args, q.token)
+	}
+	if !q.since.IsZero() {
+		sqlText += ` AND received_at >= ?`
+		args = append(args, q.since.UnixNano())
+	}
+	if !q.until.IsZero() {
+		sqlText += ` AND received_at < ?`
+		args = append(args, q.until.UnixNano())
+	}
+	if q.payload != "" {
+		sqlText += ` AND instr(payload, ?) > 0`
+		args = append(args, q.payload)
+	}
+	sqlText += ` ORDER BY id LIMIT ?`
+	args = append(args, q.limit)
+
+	rows, err := s.db.Query(sqlText, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []storedNotification{}
+	for rows.Next() {
+		var r storedNotification
+		var nanos int64
+		var text string
+		if err := rows.Scan(&r.ID, &nanos, &r.Remote, &text); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, nanos).UTC()
+		r.Notification = json.RawMessage(text)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+func init() {
+	adminMux.HandleFunc("/notifications", handleNotifications)
+}
+
+// handleNotifications responds with a JSON array of the stored
+// notifications matching the query parameters token, since and until
+// (RFC 3339 times), payload (a substring of the payload JSON) and limit
+// (100 by default):
+//
+// 		curl 'http://localhost:8080/notifications?token=<64 hex digits>&since=2013-11-13T00:00:00Z'
+func handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if notificationStore == nil {
+		http.Error(w, "notifications are not stored, start apnserver with -db", http.StatusNotFound)
+		return
+	}
+	params := r.URL.Query()
+	q := storeQuery{payload: params.Get("payload"), limit: 100}
+	if s := params.Get("token"); s != "" {
+		token, err := format.ParseToken(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.token = token.Hex()
+	}
+	for name, t := range map[string]*time.Time{"since": &q.since, "until": &q.until} {
+		if s := params.Get(name); s != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				http.Error(w, name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if s := params.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		q.limit = n
+	}
+	results, err := notificationStore.query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// tokenSet is a concurrency-safe set of device tokens. The registered
+// tokens of the options in effect are the only tokens the server accepts,
+// once any have been registered with a -tokens file or through the admin
+// endpoint. Until then every token is accepted.
+type tokenSet struct {
+	mu     sync.Mutex
+	tokens map[format.Token]bool
+
+	// Whether tokens outside the set are refused.
+	restricted bool
+}
+
+func newTokenSet() *tokenSet {
+	return &tokenSet{tokens: make(map[format.Token]bool)}
+}
+
+// add registers a token, restricting the set to the tokens registered.
+func (s *tokenSet) add(token format.Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = true
+	s.restricted = true
+}
+
+// remove unregisters a token, reporting whether it was registered.
+func (s *tokenSet) remove(token format.Token) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := s.tokens[token]
+	delete(s.tokens, token)
+	return ok
+}
+
+// accepts reports whether notifications to a token are accepted.
+func (s *tokenSet) accepts(token format.Token) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.restricted || s.tokens[token]
+}
+
+// len returns the number of tokens registered, and whether the set
+// restricts tokens at all.
+func (s *tokenSet) len() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tokens), s.restricted
+}
+
+// loadTokens registers the tokens listed in a file, see readTokens. The
+// set is restricted even if the file is empty.
+func loadTokens(file string) (*tokenSet, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tokens, err := readTokens(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	set := newTokenSet()
+	set.restricted = true
+	for _, token := range tokens {
+		set.add(token)
+	}
+	return set, nil
+}
+
+// readTokens reads one device token per line, in any form
+// format.ParseToken accepts. Blank lines and lines starting with # are
+// skipped.
+func readTokens(r io.Reader) ([]format.Token, error) {
+	var tokens []format.Token
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		token, err := format.ParseToken(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, scanner.Err()
+}
+
+// checkRegistered returns an InvalidToken error response for a
+// notification whose token is not registered, and reports the token to
+// the feedback service as APNs would.
+func checkRegistered(tokens *tokenSet, p apns.Packet) error {
+	token, ok := apnstest.Token(p)
+	if !ok || tokens.accepts(token) {
+		return nil
+	}
+	failedTokens.add(token)
+	return &apns.ErrorResponse{Status: format.InvalidTokenStatus, Identifier: apnstest.Identifier(p)}
+}
+
+func init() {
+	adminMux.HandleFunc("/tokens", handleRegisterTokens)
+	adminMux.HandleFunc("/tokens/", handleUnregisterToken)
+}
+
+// handleRegisterTokens registers the tokens in the body of a POST request,
+// written as in a -tokens file:
+//
+// 		curl --data-binary @tokens.txt http://localhost:8080/tokens
+func handleRegisterTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tokens, err := readTokens(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	registered := currentOptions().tokens
+	for _, token := range tokens {
+		registered.add(token)
+	}
+	logger.Info("Registered tokens", "tokens", len(tokens))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUnregisterToken unregisters the token named by the path of a
+// DELETE request, so that notifications to it fail with InvalidToken:
+//
+// 		curl -X DELETE http://localhost:8080/tokens/<64 hex digits>
+func handleUnregisterToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, err := format.ParseToken(strings.TrimPrefix(r.URL.Path, "/tokens/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !currentOptions().tokens.remove(token) {
+		http.Error(w, "token not registered", http.StatusNotFound)
+		return
+	}
+	logger.Info("Unregistered token", tokenAttr(token))
+	w.WriteHeader(http.StatusNoContent)
+}
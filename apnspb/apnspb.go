@@ -0,0 +1,98 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package apnspb encodes notifications as the Notification protocol buffer
+// message defined in notification.proto, for passing them through queues
+// between the service producing notifications and the one sending them.
+//
+// The message types in notification.pb.go are generated from
+// notification.proto; Marshal and Unmarshal convert between them and the
+// notification formats.
+package apnspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative notification.proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+	"google.golang.org/protobuf/proto"
+)
+
+// Marshal encodes a notification as a Notification message.
+func Marshal(pn apns.PushNotification) ([]byte, error) {
+	var m Notification
+	var err error
+	switch n := pn.(type) {
+	case *format.SimpleNotification:
+		m.Command, m.DeviceToken = int32(format.SimpleNotificationCMD), n.Token[:]
+		m.Payload, err = n.MarshalPayload()
+	case *format.EnhancedNotification:
+		m.Command, m.DeviceToken = int32(format.EnhancedNotificationCMD), n.Token[:]
+		m.Identifier, m.Expiry = n.Identifier, uint32(n.Expiry)
+		m.Payload, err = n.MarshalPayload()
+	case *format.Notification:
+		m.Command, m.DeviceToken = int32(format.NotificationCMD), n.Token[:]
+		m.Identifier, m.Expiry, m.Priority = n.Identifier, uint32(n.Expiry), int32(n.Priority)
+		for _, item := range n.Items {
+			m.Items = append(m.Items, &FrameItem{Number: int32(item.Number), Data: item.Data})
+		}
+		m.Payload, err = n.MarshalPayload()
+	default:
+		return nil, fmt.Errorf("cannot marshal %T", pn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(&m)
+}
+
+// Unmarshal decodes a Notification message. Unknown fields are skipped.
+// The payload is kept as RawPayload, byte for byte, so that a message
+// marshaled again is the same as the one decoded.
+func Unmarshal(data []byte) (apns.PushNotification, error) {
+	var m Notification
+	if err := proto.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	var token format.Token
+	if len(m.DeviceToken) > 0 {
+		if len(m.DeviceToken) != format.TokenSize {
+			return nil, apns.ErrInvalidToken
+		}
+		copy(token[:], m.DeviceToken)
+	}
+	if !json.Valid(m.Payload) {
+		return nil, errors.New("payload: not valid JSON")
+	}
+	payload := json.RawMessage(m.Payload)
+	switch format.Command(m.Command) {
+	case format.SimpleNotificationCMD:
+		return &format.SimpleNotification{Token: token, RawPayload: payload}, nil
+	case format.EnhancedNotificationCMD:
+		return &format.EnhancedNotification{
+			Identifier: m.Identifier,
+			Expiry:     int32(m.Expiry),
+			Token:      token,
+			RawPayload: payload,
+		}, nil
+	case format.NotificationCMD:
+		var items []format.FrameItem
+		for _, item := range m.Items {
+			items = append(items, format.FrameItem{Number: int8(item.Number), Data: item.Data})
+		}
+		return &format.Notification{
+			Token:      token,
+			Identifier: m.Identifier,
+			Expiry:     int32(m.Expiry),
+			Priority:   int8(m.Priority),
+			RawPayload: payload,
+			Items:      items,
+		}, nil
+	}
+	return nil, apns.ErrUnknownCommand
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apnspb_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnspb"
+	"github.com/cfilipov/apns/format"
+	"google.golang.org/protobuf/proto"
+)
+
+// rawPayload has spacing and a key order which marshaling a decoded
+// payload would not keep.
+var rawPayload = json.RawMessage(`{"zz": 1,  "aps":{"badge":3,"alert":"Hello"}}`)
+
+func testToken() format.Token {
+	var token format.Token
+	for i := range token {
+		token[i] = byte(i + 1)
+	}
+	return token
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		pn   apns.PushNotification
+	}{
+		{"simple", &format.SimpleNotification{
+			Token:      testToken(),
+			RawPayload: rawPayload,
+		}},
+		{"enhanced", &format.EnhancedNotification{
+			Token:      testToken(),
+			Identifier: -7,
+			Expiry:     -1, // past 2038, so the top bit is set
+			RawPayload: rawPayload,
+		}},
+		{"command 2", &format.Notification{
+			Token:      testToken(),
+			Identifier: 42,
+			Expiry:     1700000000,
+			Priority:   10,
+			RawPayload: rawPayload,
+			Items: []format.FrameItem{
+				{Number: 9, Data: []byte{1, 2, 3}},
+				{Number: -3, Data: []byte("x")},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := apnspb.Marshal(tt.pn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := apnspb.Unmarshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.pn) {
+				t.Errorf("decoded %#v, want %#v", got, tt.pn)
+			}
+			again, err := apnspb.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, data) {
+				t.Errorf("marshaled again as %x, want %x", again, data)
+			}
+		})
+	}
+}
+
+func TestMarshalFields(t *testing.T) {
+	pn := &format.Notification{
+		Token:      testToken(),
+		Identifier: 42,
+		Expiry:     -2,
+		Priority:   5,
+		RawPayload: rawPayload,
+		Items:      []format.FrameItem{{Number: 9, Data: []byte{1}}},
+	}
+	data, err := apnspb.Marshal(pn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m apnspb.Notification
+	if err := proto.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	token := testToken()
+	want := &apnspb.Notification{
+		Command:     2,
+		DeviceToken: token[:],
+		Identifier:  42,
+		Expiry:      0xfffffffe,
+		Priority:    5,
+		Payload:     rawPayload,
+		Items:       []*apnspb.FrameItem{{Number: 9, Data: []byte{1}}},
+	}
+	if !proto.Equal(&m, want) {
+		t.Errorf("got %v, want %v", &m, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	marshal := func(m *apnspb.Notification) []byte {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	token := testToken()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short token", marshal(&apnspb.Notification{Command: 2, DeviceToken: token[:31], Payload: rawPayload})},
+		{"invalid payload", marshal(&apnspb.Notification{Command: 2, DeviceToken: token[:], Payload: []byte(`{"aps":`)})},
+		{"unknown command", marshal(&apnspb.Notification{Command: 9, DeviceToken: token[:], Payload: rawPayload})},
+		{"truncated", marshal(&apnspb.Notification{Command: 2, DeviceToken: token[:], Payload: rawPayload})[:10]},
+	}
+	for _, tt := range tests {
+		if pn, err := apnspb.Unmarshal(tt.data); err == nil {
+			t.Errorf("%s: decoded %v, want an error", tt.name, pn)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package apnstest provides a mock APNs gateway for tests, running in the
+// same process as the code under test. It validates notifications the
+// same way as the apnserver command:
+//
+// 		srv := apnstest.NewServer()
+// 		defer srv.Close()
+//
+// 		client, _ := apns.DialClient(func() (net.Conn, error) {
+// 			return net.Dial("tcp", srv.Addr())
+// 		})
+// 		client.Send(pn)
+// 		client.Close()
+//
+// 		got := srv.WaitReceived(1, time.Second)
+package apnstest
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// Server is a mock APNs gateway listening on a local port. Like APNs, it
+// answers a notification only to reject it, and closes the connection
+// after doing so.
+type Server struct {
+	// Respond, if set, is called for every valid notification and returns
+	// the status to reject it with, or NoErrStatus to accept it. Invalid
+	// notifications are rejected as APNs would, see Validate.
+	Respond func(pn apns.PushNotification) format.Status
+
+	// TLS, if set, is the configuration to serve TLS with. It must be set
+	// before Start.
+	TLS *tls.Config
+
+	ln       net.Listener
+	mu       sync.Mutex
+	cond     *sync.Cond
+	received []apns.PushNotification
+	conns    map[net.Conn]bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	if err := s.Start(); err != nil {
+		panic("apnstest: failed to listen on a port: " + err.Error())
+	}
+	return s
+}
+
+// NewUnstartedServer returns a new Server but doesn't start it, so that
+// Respond and TLS can be set first.
+func NewUnstartedServer() *Server {
+	s := &Server{conns: make(map[net.Conn]bool)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Start listens on a random local port and serves clients in the
+// background.
+func (s *Server) Start() error {
+	if s.ln != nil {
+		return errors.New("apnstest: server already started")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	if s.TLS != nil {
+		ln = tls.NewListener(ln, s.TLS)
+	}
+	s.ln = ln
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Addr returns the address the server listens on, as host:port.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Received returns the notifications received so far, in the order they
+// arrived, whether or not they were rejected.
+func (s *Server) Received() []apns.PushNotification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]apns.PushNotification(nil), s.received...)
+}
+
+// WaitReceived waits until at least n notifications have been received,
+// or the timeout elapses, and returns those received.
+func (s *Server) WaitReceived(n int, timeout time.Duration) []apns.PushNotification {
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.received) < n && !s.closed && time.Now().Before(deadline) {
+		s.cond.Wait()
+	}
+	return append([]apns.PushNotification(nil), s.received...)
+}
+
+// Close stops listening, closes the connections of all clients and waits
+// for them to be handled.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle reads notifications from a client until it hangs up or one is
+// rejected.
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		p, err := apns.ReadCommand(conn)
+		var perr *apns.ParseError
+		if errors.As(err, &perr) {
+			if resp := ParseErrorResponse(perr); resp != nil {
+				resp.WriteTo(conn)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		pn, ok := p.(apns.PushNotification)
+		if !ok {
+			return
+		}
+		s.record(pn)
+		resp := Validate(p)
+		if resp == nil && s.Respond != nil {
+			if status := s.Respond(pn); status != format.NoErrStatus {
+				resp = &apns.ErrorResponse{Status: status, Identifier: Identifier(p)}
+			}
+		}
+		if resp != nil {
+			resp.WriteTo(conn)
+			hangUp(conn)
+			return
+		}
+	}
+}
+
+// hangUp closes the sending side of a connection and discards what the
+// client still sends, for up to a second, so that closing it does not
+// reset the connection before the client has read the error response.
+func hangUp(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	io.Copy(ioutil.Discard, conn)
+}
+
+func (s *Server) record(pn apns.PushNotification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, pn)
+	s.cond.Broadcast()
+}
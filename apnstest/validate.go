@@ -0,0 +1,117 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apnstest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// The fields of a packet named by ParseError which hold the device token
+// or the payload. Command 2 notifications name their items by number.
+var (
+	tokenFields   = map[string]bool{"token length": true, "token": true, "item 1": true}
+	payloadFields = map[string]bool{"payload length": true, "payload": true, "item 2": true, "frame length": true, "frame": true}
+)
+
+// ParseErrorResponse returns the error response APNs would send for a
+// packet which could not be decoded, or nil if it would just close the
+// connection, as for a packet cut short.
+//
+// APNs has no status for a payload that is not valid JSON; the mock
+// responds with MissingPayloadStatus so that clients treat it as the
+// permanent failure it is.
+func ParseErrorResponse(perr *apns.ParseError) *apns.ErrorResponse {
+	var status format.Status
+	var lerr *format.LengthError
+	var serr *json.SyntaxError
+	var terr *json.UnmarshalTypeError
+	switch {
+	case errors.As(perr.Err, &serr), errors.As(perr.Err, &terr):
+		status = format.MissingPayloadStatus
+	case tokenFields[perr.Field] && !errors.Is(perr.Err, io.ErrUnexpectedEOF):
+		status = format.InvalidTokenSizeStatus
+	case payloadFields[perr.Field] && errors.As(perr.Err, &lerr):
+		status = format.InvalidPayloadSizeStatus
+	default:
+		return nil
+	}
+	return &apns.ErrorResponse{Status: status, Identifier: Identifier(perr.Packet)}
+}
+
+// Validate checks a decoded notification as APNs would, returning an
+// error response for a missing token or payload, or a payload over the
+// size limit of its command. It returns nil for valid notifications and
+// for packets which are not notifications.
+func Validate(p apns.Packet) *apns.ErrorResponse {
+	v, ok := p.(validator)
+	if !ok {
+		return nil
+	}
+	token, _ := Token(p)
+	resp := &apns.ErrorResponse{Identifier: Identifier(p)}
+	switch {
+	case token.IsZero():
+		resp.Status = format.MissingTokenStatus
+	case payloadMissing(p):
+		resp.Status = format.MissingPayloadStatus
+	default:
+		var serr *format.PayloadSizeError
+		if err := v.Validate(); errors.As(err, &serr) {
+			resp.Status = serr.Status()
+		} else {
+			return nil
+		}
+	}
+	return resp
+}
+
+// validator is implemented by the notification formats.
+type validator interface {
+	Validate() error
+}
+
+// payloadMissing reports whether a notification arrived without a payload.
+func payloadMissing(p apns.Packet) bool {
+	switch n := p.(type) {
+	case *format.SimpleNotification:
+		return n.Payload == nil && n.RawPayload == nil
+	case *format.EnhancedNotification:
+		return n.Payload == nil && n.RawPayload == nil
+	case *format.Notification:
+		return n.Payload == nil && n.RawPayload == nil
+	}
+	return false
+}
+
+// Token returns the device token of a notification, and false for packets
+// without one.
+func Token(p apns.Packet) (format.Token, bool) {
+	switch n := p.(type) {
+	case *format.SimpleNotification:
+		return n.Token, true
+	case *format.EnhancedNotification:
+		return n.Token, true
+	case *format.Notification:
+		return n.Token, true
+	}
+	return format.Token{}, false
+}
+
+// Identifier returns the identifier of a notification, or zero for simple
+// notifications which carry none.
+func Identifier(p apns.Packet) int32 {
+	switch n := p.(type) {
+	case *format.EnhancedNotification:
+		return n.Identifier
+	case *format.Notification:
+		return n.Identifier
+	}
+	return 0
+}
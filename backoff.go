@@ -0,0 +1,49 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff configures how long a Client waits between reconnection attempts.
+// The delay before attempt n (counting from zero) is Initial multiplied by
+// Multiplier n times, capped at Max, and then randomly spread by up to
+// Jitter (a fraction of the delay) in either direction so that many clients
+// disconnected at once don't all reconnect at the same moment.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+
+	// MaxRetries is the number of reconnection attempts made before the
+	// client gives up. Zero means retry forever.
+	MaxRetries int
+}
+
+// DefaultBackoff is the reconnection policy used by DialClient.
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+	MaxRetries: 10,
+}
+
+// Delay returns how long to wait before the given reconnection attempt.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	d += d * b.Jitter * (2*rand.Float64() - 1)
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+// SendAll encodes the notifications and writes them all to the connection
+// together. On a plain TCP connection each is encoded into its own buffer
+// and written with a single vectored write (writev). Other writers, such
+// as the *tls.Conn of an APNs connection, would write each buffer of a
+// vectored write separately, one TLS record apiece, so for them the
+// notifications are encoded into one buffer and written with a single
+// Write instead. Either way notifications are batched into as few packets
+// as possible. Nothing is written if any notification fails to encode.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		For optimum performance, you should batch multiple notifications
+// 		in a single transmission over the interface, either explicitly or
+// 		using a TCP/IP Nagle's algorithm.
+func SendAll(w io.Writer, notifs []PushNotification) error {
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		bufs := make(net.Buffers, len(notifs))
+		for i, pn := range notifs {
+			var buf bytes.Buffer
+			if _, err := pn.WriteTo(&buf); err != nil {
+				return err
+			}
+			bufs[i] = buf.Bytes()
+		}
+		_, err := bufs.WriteTo(w)
+		return err
+	}
+	var buf bytes.Buffer
+	for _, pn := range notifs {
+		if _, err := pn.WriteTo(&buf); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// MakeNotifications decodes a batch of notifications, given either as a
+// JSON array of notification documents or as a stream of them (such as
+// newline delimited JSON), ready to pass to SendAll. Each document is
+// decoded as by MakeNotification.
+func MakeNotifications(data []byte) ([]PushNotification, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var docs []json.RawMessage
+		if err := json.Unmarshal(trimmed, &docs); err != nil {
+			return nil, err
+		}
+		notifs := make([]PushNotification, len(docs))
+		for i, doc := range docs {
+			pn, err := MakeNotification(doc)
+			if err != nil {
+				return nil, fmt.Errorf("Notification %d: %w", i, err)
+			}
+			notifs[i] = pn
+		}
+		return notifs, nil
+	}
+	return ReadNotifications(bytes.NewReader(data))
+}
+
+// ReadNotifications decodes a stream of notification documents from r
+// until it ends. Each document is decoded as by MakeNotification.
+func ReadNotifications(r io.Reader) ([]PushNotification, error) {
+	dec := json.NewDecoder(r)
+	var notifs []PushNotification
+	for i := 0; ; i++ {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return notifs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Notification %d: %w", i, err)
+		}
+		pn, err := MakeNotification(doc)
+		if err != nil {
+			return nil, fmt.Errorf("Notification %d: %w", i, err)
+		}
+		notifs = append(notifs, pn)
+	}
+}
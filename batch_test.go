@@ -0,0 +1,58 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+// countingWriter records each Write, as a *tls.Conn would send each as
+// its own record.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestSendAllSingleWrite(t *testing.T) {
+	notifs := []apns.PushNotification{testNotification(1), testNotification(2), testNotification(3)}
+	var want []byte
+	for _, pn := range notifs {
+		b, err := pn.(*format.Notification).AppendTo(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, b...)
+	}
+
+	var w countingWriter
+	if err := apns.SendAll(&w, notifs); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("SendAll made %d writes, want 1", w.writes)
+	}
+	if !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("SendAll wrote %x, want %x", w.Bytes(), want)
+	}
+
+	bad := testNotification(4)
+	bad.Token = format.Token{}
+	w = countingWriter{}
+	if err := apns.SendAll(&w, append(notifs, bad)); err == nil {
+		t.Error("SendAll encoded a notification without a token")
+	}
+	if w.writes != 0 {
+		t.Errorf("SendAll wrote %d times before failing to encode", w.writes)
+	}
+}
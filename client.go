@@ -0,0 +1,609 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// DefaultErrorWindow is how long a Client waits for an error response after
+// writing a notification before assuming it was accepted by APNs.
+const DefaultErrorWindow = 2 * time.Second
+
+// ErrDiscarded is the result of a notification that was sent after a
+// notification APNs rejected. APNs discards everything that follows a
+// failed notification on the same connection, so these must be resent,
+// unless the client's Policy resends them itself.
+var ErrDiscarded = errors.New("Notification discarded after an earlier error.")
+
+// ErrShutdown ends a connection on which APNs sent an error response with
+// the Shutdown status, meaning the gateway is going away for maintenance.
+var ErrShutdown = errors.New("Gateway is shutting down.")
+
+// ErrClientClosed is returned when sending on a Client that has been closed.
+var ErrClientClosed = errors.New("Client is closed.")
+
+// Client sends notifications over a single APNs connection and tracks the
+// outcome of each one.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		If you send a notification that is accepted by APNs, nothing is
+// 		returned.
+//
+// Because success is silent, a Client considers a notification delivered
+// once ErrorWindow has elapsed without an error response referencing it.
+type Client struct {
+	// ErrorWindow is how long to wait for an error response after a
+	// notification is written. Defaults to DefaultErrorWindow.
+	ErrorWindow time.Duration
+
+	// Backoff controls reconnection when the gateway closes the connection.
+	// It only applies to clients created with DialClient.
+	Backoff Backoff
+
+	// Limiter, if set, caps the rate at which notifications are written.
+	Limiter *RateLimiter
+
+	// Spool, if set, durably records notifications sent with SendAsync
+	// until their outcome is known. See Resume.
+	Spool Spool
+
+	// IDs assigns identifiers to notifications sent with a zero
+	// identifier, so error responses can be matched to them. NewClient
+	// sets it to a fresh allocator; set it to nil to send identifiers
+	// unchanged.
+	IDs *IdentifierAllocator
+
+	// Clock is used to time error windows and reconnection delays.
+	// NewClient sets it to SystemClock.
+	Clock Clock
+
+	// Policy decides what to do about each error response. If nil,
+	// DefaultRetryPolicy is used.
+	Policy RetryPolicy
+
+	// RetryLimit is how many times a notification is resent under
+	// RetryAction. NewClient sets it to DefaultRetryLimit.
+	RetryLimit int
+
+	// InvalidTokens, if set, receives the device token of each
+	// notification rejected with a status the Policy maps to
+	// InvalidateTokenAction, as a feedback tuple timestamped when the
+	// error response arrived. That lets tokens the gateway rejects go
+	// through the same handling as those the feedback service reports.
+	InvalidTokens FeedbackHandler
+
+	hooks   []Hooks
+	dial    func() (net.Conn, error)
+	conn    net.Conn
+	mu      sync.Mutex
+	pending []*Result
+	resend  []*Result
+	closed  bool
+	err     error
+
+	// Set by Drain to refuse new sends.
+	draining bool
+
+	// Time of the most recent write, so Drain can wait out its window.
+	lastWrite time.Time
+
+	// Notifications the pipeline has taken off notifs but not yet
+	// written, while it waits on the rate limiter.
+	inflight int
+
+	notifs   chan PushNotification
+	errs     chan *format.NotificationError
+	pipeOnce sync.Once
+
+	// Errors waiting to be received from errs. They are queued only once
+	// the pipeline has started, and without limit, so a slow receiver
+	// loses none. errsWake tells forwardErrors the queue has grown or
+	// errsEnded has been set.
+	errq     []*format.NotificationError
+	errsWake chan struct{}
+	piped    bool
+
+	// Set once the connection has ended for good. Nothing more is queued,
+	// and errs is closed once the queue is empty.
+	errsEnded bool
+}
+
+// NewClient wraps an established connection (see DialAPN) and starts
+// listening for error responses on it.
+func NewClient(conn net.Conn) *Client {
+	c := newClient(conn)
+	go c.readLoop()
+	return c
+}
+
+func newClient(conn net.Conn) *Client {
+	return &Client{
+		ErrorWindow: DefaultErrorWindow,
+		RetryLimit:  DefaultRetryLimit,
+		IDs:         NewIdentifierAllocator(),
+		Clock:       SystemClock,
+		conn:        conn,
+		notifs:      make(chan PushNotification, pipelineBuffer),
+		errs:        make(chan *format.NotificationError, pipelineBuffer),
+		errsWake:    make(chan struct{}, 1),
+	}
+}
+
+// DialClient creates a Client using dial to open its connection, for
+// example:
+//
+// 		c, err := apns.DialClient(func() (net.Conn, error) {
+// 			return apns.DialAPN(&cert, apns.SANDBOX, false)
+// 		})
+//
+// Whenever the connection is lost, the client calls dial again following
+// its Backoff policy. Sends fail with the connection error while the client
+// is reconnecting.
+func DialClient(dial func() (net.Conn, error)) (*Client, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(conn)
+	c.Backoff = DefaultBackoff
+	c.dial = dial
+	go c.readLoop()
+	return c, nil
+}
+
+// Result is the eventual outcome of a notification sent with SendAsync.
+type Result struct {
+	// The notification this result belongs to.
+	Notification PushNotification
+
+	done chan struct{}
+	err  error
+	once sync.Once
+
+	// Sequence number in the client's Spool, if spooled.
+	seq     uint64
+	spooled bool
+
+	// Ends the error window of the most recent write.
+	timer Timer
+
+	// How many times the notification has been resent under RetryAction,
+	// and the error it resolves with if it cannot be resent again.
+	retries  int
+	retryErr error
+}
+
+func newResult(pn PushNotification) *Result {
+	return &Result{
+		Notification: pn,
+		done:         make(chan struct{}),
+	}
+}
+
+// Done returns a channel that is closed once the result is known.
+func (r *Result) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the result is known and returns it. A nil error means
+// no error response was received within the error window. Otherwise the
+// error is a *StatusError for a rejection by APNs, ErrDiscarded, or the
+// error that broke the connection.
+func (r *Result) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Err returns the result without blocking. It is only meaningful after
+// Done has been closed.
+func (r *Result) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+func (r *Result) resolve(err error) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
+
+// Send writes a notification to the connection without waiting for its
+// outcome.
+func (c *Client) Send(pn PushNotification) error {
+	pn, err := c.prepare(pn)
+	if err != nil {
+		return err
+	}
+	c.wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.draining {
+		return ErrClientClosed
+	}
+	if c.err != nil {
+		return c.err
+	}
+	c.lastWrite = c.Clock.Now()
+	return c.write(c.conn, pn)
+}
+
+// SendAsync writes a notification and returns a Result which resolves to
+// nil once the error window elapses, or to a *StatusError if APNs
+// rejected it.
+func (c *Client) SendAsync(pn PushNotification) *Result {
+	pn, err := c.prepare(pn)
+	res := newResult(pn)
+	if err != nil {
+		res.resolve(err)
+		return res
+	}
+	if c.Spool != nil {
+		seq, err := c.Spool.Append(res.Notification)
+		if err != nil {
+			res.resolve(err)
+			return res
+		}
+		res.seq, res.spooled = seq, true
+	}
+	return c.send(res)
+}
+
+// Resume resends every notification left pending in the client's Spool,
+// typically by a previous process that stopped before their outcome was
+// known.
+func (c *Client) Resume() ([]*Result, error) {
+	if c.Spool == nil {
+		return nil, nil
+	}
+	entries, err := c.Spool.Pending()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Result, len(entries))
+	for i, e := range entries {
+		pn, err := c.prepare(e.Notification)
+		res := newResult(pn)
+		res.seq, res.spooled = e.Seq, true
+		if err != nil {
+			res.resolve(err)
+			results[i] = res
+			continue
+		}
+		results[i] = c.send(res)
+	}
+	return results, nil
+}
+
+// send writes the notification of a new result and starts its error window.
+func (c *Client) send(res *Result) *Result {
+	c.wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		res.resolve(ErrClientClosed)
+		return res
+	}
+	return c.writeResult(res)
+}
+
+// writeResult writes the notification of a result and starts its error
+// window. The caller holds c.mu.
+func (c *Client) writeResult(res *Result) *Result {
+	if c.closed {
+		res.resolve(ErrClientClosed)
+		return res
+	}
+	if c.err != nil {
+		res.resolve(c.err)
+		return res
+	}
+	c.lastWrite = c.Clock.Now()
+	if err := c.write(c.conn, res.Notification); err != nil {
+		res.resolve(err)
+		return res
+	}
+	c.pending = append(c.pending, res)
+	res.timer = c.Clock.AfterFunc(c.ErrorWindow, func() { c.expire(res) })
+	return res
+}
+
+// assign gives a notification an identifier if it lacks one.
+func (c *Client) assign(pn PushNotification) PushNotification {
+	if c.IDs == nil {
+		return pn
+	}
+	return c.IDs.Assign(pn)
+}
+
+// wait blocks until the rate limiter, if any, allows another notification.
+func (c *Client) wait() {
+	if c.Limiter != nil {
+		c.Limiter.Wait()
+	}
+}
+
+// Err returns the error that broke the connection, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the underlying connection and stops any reconnection.
+// Notifications still inside their error window resolve with the resulting
+// connection error.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.mu.Unlock()
+	return conn.Close()
+}
+
+// expire resolves a result as delivered once its error window has elapsed.
+func (c *Client) expire(res *Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p == res {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			c.complete(res)
+			res.resolve(nil)
+			return
+		}
+	}
+}
+
+// readLoop reads from the current connection until it fails and then
+// reconnects, if the client knows how to.
+func (c *Client) readLoop() {
+	defer func() {
+		c.mu.Lock()
+		c.errsEnded = true
+		if c.piped {
+			c.wakeErrors()
+		} else {
+			close(c.errs)
+		}
+		c.mu.Unlock()
+	}()
+	for {
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		err := c.read(conn)
+		c.fail(err)
+		conn.Close()
+		c.onStateChange(StateEvent{State: Disconnected, Err: err})
+		if !c.reconnect() {
+			c.dropResend()
+			return
+		}
+		c.resendAll()
+	}
+}
+
+// reconnect dials a new connection, retrying according to the client's
+// Backoff. It reports whether the client is connected again.
+func (c *Client) reconnect() bool {
+	if c.dial == nil {
+		return false
+	}
+	for attempt := 0; c.Backoff.MaxRetries == 0 || attempt < c.Backoff.MaxRetries; attempt++ {
+		c.Clock.Sleep(c.Backoff.Delay(attempt))
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return false
+		}
+		c.onStateChange(StateEvent{State: Reconnecting, Attempt: attempt})
+		conn, err := c.dial()
+		c.onReconnect(attempt, err)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return false
+		}
+		c.conn, c.err = conn, nil
+		c.mu.Unlock()
+		c.onStateChange(StateEvent{State: Connected})
+		return true
+	}
+	return false
+}
+
+// read waits for error responses and resolves the affected results. APNs
+// closes the connection after sending an error response, so this returns
+// at the first read error.
+func (c *Client) read(conn net.Conn) error {
+	for {
+		p, err := ReadCommand(conn)
+		if err != nil {
+			return err
+		}
+		if nerr, ok := p.(*format.NotificationError); ok {
+			c.onError(nerr)
+			c.reject(*nerr)
+			c.mu.Lock()
+			c.queueError(nerr)
+			c.mu.Unlock()
+			if nerr.Status == format.ShutdownStatus {
+				return ErrShutdown
+			}
+		}
+	}
+}
+
+// reject applies the client's Policy to an error response, resolving the
+// notification it identifies and every notification sent after it, or
+// setting them aside to be resent once the client has reconnected.
+func (c *Client) reject(nerr format.NotificationError) {
+	c.mu.Lock()
+	action := c.Policy.Action(nerr.Status)
+	i := -1
+	for j, p := range c.pending {
+		if id, ok := notificationID(p.Notification); ok && id == nerr.Identifier {
+			i = j
+			break
+		}
+	}
+
+	var rejected *Result
+	discarded := c.pending[i+1:]
+	if nerr.Status == format.ShutdownStatus {
+		// The identifier is that of the last notification APNs accepted,
+		// so everything up to it was delivered.
+		for _, p := range c.pending[:i+1] {
+			p.timer.Stop()
+			c.complete(p)
+			p.resolve(nil)
+		}
+		c.pending = nil
+	} else if i < 0 {
+		c.mu.Unlock()
+		return
+	} else {
+		// APNs accepted everything sent before the rejected notification,
+		// and hangs up after the error response, so those are delivered
+		// rather than left to fail with the connection.
+		for _, p := range c.pending[:i] {
+			p.timer.Stop()
+			c.complete(p)
+			p.resolve(nil)
+		}
+		rejected = c.pending[i]
+		c.pending = nil
+	}
+
+	var invalid format.Token
+	statusErr := &StatusError{
+		Command:    format.NotificationErrorCMD,
+		Status:     nerr.Status,
+		Identifier: nerr.Identifier,
+	}
+	if rejected != nil {
+		if action == RetryAction && rejected.retries < c.RetryLimit {
+			rejected.retries++
+			rejected.retryErr = statusErr
+			c.setAside(rejected)
+		} else {
+			// A rejected notification won't succeed if sent again, so it
+			// leaves the spool; the discarded ones stay for Resume.
+			rejected.timer.Stop()
+			c.complete(rejected)
+			rejected.resolve(statusErr)
+			if action == InvalidateTokenAction {
+				invalid = notificationToken(rejected.Notification)
+			}
+		}
+	}
+	for _, p := range discarded {
+		if action == RetryAction || action == ReconnectAction {
+			c.setAside(p)
+		} else {
+			p.resolve(ErrDiscarded)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.InvalidTokens != nil && !invalid.IsZero() {
+		c.InvalidTokens.HandleFeedback(format.Feedback{
+			Timestamp: uint32(c.Clock.Now().Unix()),
+			Token:     invalid,
+		})
+	}
+}
+
+// setAside stops the error window of a pending result and keeps it to be
+// resent once the client has reconnected. The caller must hold c.mu.
+func (c *Client) setAside(res *Result) {
+	res.timer.Stop()
+	c.resend = append(c.resend, res)
+}
+
+// resendAll sends again the notifications set aside by reject, keeping
+// their original results. Each stays set aside until it is written, so
+// Drain waits for them, and they go out even while the client drains.
+func (c *Client) resendAll() {
+	for {
+		c.wait()
+		c.mu.Lock()
+		if len(c.resend) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		res := c.resend[0]
+		c.resend = c.resend[1:]
+		c.writeResult(res)
+		c.mu.Unlock()
+	}
+}
+
+// dropResend gives up on notifications set aside by reject when the
+// client cannot reconnect. A notification APNs rejected resolves with its
+// *StatusError, the others with ErrDiscarded.
+func (c *Client) dropResend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, res := range c.resend {
+		if res.retryErr != nil {
+			c.complete(res)
+			res.resolve(res.retryErr)
+			continue
+		}
+		res.resolve(ErrDiscarded)
+	}
+	c.resend = nil
+}
+
+// fail resolves every pending result with the error that ended the
+// connection and prevents further sends.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for _, p := range c.pending {
+		p.resolve(err)
+	}
+	c.pending = nil
+}
+
+// complete removes a result's notification from the spool, if spooled.
+func (c *Client) complete(res *Result) {
+	if res.spooled {
+		c.Spool.Complete(res.seq)
+	}
+}
+
+// notificationID returns the identifier of a notification if its format
+// carries one. Simple notifications (command 0) have no identifier.
+func notificationID(pn PushNotification) (int32, bool) {
+	switch n := pn.(type) {
+	case *format.EnhancedNotification:
+		return n.Identifier, true
+	case *format.Notification:
+		return n.Identifier, true
+	}
+	return 0, false
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// testNotification returns a valid notification with the given identifier,
+// to a token made from it.
+func testNotification(id int32) *format.Notification {
+	var token format.Token
+	token[0], token[31] = byte(id), 0xff
+	return &format.Notification{
+		Identifier: id,
+		Token:      token,
+		Priority:   10,
+		RawPayload: []byte(`{"aps":{"alert":"test"}}`),
+	}
+}
+
+// dialTest connects a client to a mock gateway. Its error window is long
+// enough that no result resolves by the window elapsing during a test.
+func dialTest(t *testing.T, srv *apnstest.Server) *apns.Client {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := apns.NewClient(conn)
+	c.ErrorWindow = time.Minute
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// waitResult waits for a result, failing the test if it takes too long.
+func waitResult(t *testing.T, res *apns.Result) error {
+	t.Helper()
+	select {
+	case <-res.Done():
+		return res.Err()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("notification %v: no result", res.Notification)
+		return nil
+	}
+}
+
+// rejectAt returns a Respond function rejecting the notification with the
+// identifier id with status, once release is closed, so that every
+// notification is written before the error response.
+func rejectAt(id int32, status format.Status, release <-chan struct{}) func(apns.PushNotification) format.Status {
+	return func(pn apns.PushNotification) format.Status {
+		if pn.(*format.Notification).Identifier != id {
+			return format.NoErrStatus
+		}
+		<-release
+		return status
+	}
+}
+
+func TestRejectResolvesEarlierAsDelivered(t *testing.T) {
+	release := make(chan struct{})
+	srv := apnstest.NewUnstartedServer()
+	srv.Respond = rejectAt(3, format.MissingTopicStatus, release)
+	srv.Start()
+	defer srv.Close()
+
+	spool, err := apns.OpenFileSpool(filepath.Join(t.TempDir(), "spool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	c := dialTest(t, srv)
+	c.Spool = spool
+	var results []*apns.Result
+	for id := int32(1); id <= 5; id++ {
+		results = append(results, c.SendAsync(testNotification(id)))
+	}
+	close(release)
+
+	for i, res := range results[:2] {
+		if err := waitResult(t, res); err != nil {
+			t.Errorf("notification %d accepted before the rejection: got %v, want nil", i+1, err)
+		}
+	}
+	var statusErr *apns.StatusError
+	if err := waitResult(t, results[2]); !errors.As(err, &statusErr) || statusErr.Status != format.MissingTopicStatus {
+		t.Errorf("rejected notification: got %v, want the MissingTopic status", err)
+	}
+	for i, res := range results[3:] {
+		if err := waitResult(t, res); err != apns.ErrDiscarded {
+			t.Errorf("notification %d sent after the rejection: got %v, want ErrDiscarded", i+4, err)
+		}
+	}
+
+	// Only the discarded notifications are left to send again.
+	pending, err := spool.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("spool has %d notifications pending, want the 2 discarded", len(pending))
+	}
+	for i, e := range pending {
+		if id := e.Notification.(*format.Notification).Identifier; id != int32(i+4) {
+			t.Errorf("pending notification %d has identifier %d, want %d", i, id, i+4)
+		}
+	}
+}
+
+func TestRejectShutdownResolvesUpToIdentifier(t *testing.T) {
+	release := make(chan struct{})
+	srv := apnstest.NewUnstartedServer()
+	// A Shutdown response identifies the last notification accepted.
+	srv.Respond = rejectAt(2, format.ShutdownStatus, release)
+	srv.Start()
+	defer srv.Close()
+
+	c := dialTest(t, srv)
+	var results []*apns.Result
+	for id := int32(1); id <= 3; id++ {
+		results = append(results, c.SendAsync(testNotification(id)))
+	}
+	close(release)
+
+	for i, res := range results[:2] {
+		if err := waitResult(t, res); err != nil {
+			t.Errorf("notification %d: got %v, want nil", i+1, err)
+		}
+	}
+	// The client cannot reconnect, so the notification to send again is
+	// given up on.
+	if err := waitResult(t, results[2]); err != apns.ErrDiscarded {
+		t.Errorf("notification 3: got %v, want ErrDiscarded", err)
+	}
+}
+
+func TestFailResolvesPendingWithConnectionError(t *testing.T) {
+	srv := apnstest.NewServer()
+	c := dialTest(t, srv)
+	results := []*apns.Result{
+		c.SendAsync(testNotification(1)),
+		c.SendAsync(testNotification(2)),
+	}
+	srv.WaitReceived(2, 5*time.Second)
+	srv.Close()
+
+	for i, res := range results {
+		if err := waitResult(t, res); err == nil {
+			t.Errorf("notification %d: got nil, want the connection error", i+1)
+		}
+	}
+	if c.Err() == nil {
+		t.Error("Err() = nil after the connection was lost")
+	}
+	if err := c.Send(testNotification(3)); err == nil {
+		t.Error("Send after the connection was lost: got nil, want an error")
+	}
+}
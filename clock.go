@@ -0,0 +1,45 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// Clock is the source of time for clients, rate limiters, and expiry
+// helpers. Tests can supply their own implementation to control time
+// deterministically.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled by Clock.AfterFunc.
+type Timer interface {
+	Stop() bool
+}
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// ExpiryAfter returns the encoded expiry date for a notification which
+// should be discarded ttl after the clock's now, as format.ExpiryAfter
+// does. Dates after January 2038 come out negative, and dates past 2106
+// are refused with format.ErrExpiryRange.
+func ExpiryAfter(clock Clock, ttl time.Duration) (int32, error) {
+	return format.ExpiryAfter(clock.Now(), ttl)
+}
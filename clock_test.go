@@ -0,0 +1,37 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+func TestExpiryAfterPast2038(t *testing.T) {
+	now := time.Date(2040, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := &stoppedClock{now: now}
+	expiry, err := apns.ExpiryAfter(clock, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := format.Notification{Expiry: expiry}
+	if got, want := n.ExpiryTime(), now.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("expiry %d is %v, want %v", expiry, got, want)
+	}
+
+	if err := n.SetTTLAt(now, time.Hour); err != nil || n.Expiry != expiry {
+		t.Errorf("SetTTLAt gave %d, %v; want %d like ExpiryAfter", n.Expiry, err, expiry)
+	}
+	if expiry, err := apns.ExpiryAfter(clock, 0); expiry != 0 || err != nil {
+		t.Errorf("ExpiryAfter with no ttl: got %d, %v; want 0", expiry, err)
+	}
+	clock.now = time.Date(2106, time.February, 7, 6, 0, 0, 0, time.UTC)
+	if _, err := apns.ExpiryAfter(clock, time.Hour); err != format.ErrExpiryRange {
+		t.Errorf("ExpiryAfter past 2106: got %v, want ErrExpiryRange", err)
+	}
+}
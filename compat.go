@@ -0,0 +1,59 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import "github.com/cfilipov/apns/format"
+
+// The packet formats are implemented once, in the format package. These
+// aliases keep code written against the apns package names working.
+
+// ErrorResponse is the error response packet (command 8) APNs sends before
+// closing a connection. It is the type ReadCommand returns for command 8
+// and the one the Client reports errors with.
+type ErrorResponse = format.NotificationError
+
+// StatusError is the name the error response goes by as the error a
+// Result resolves with when APNs rejected its notification. Being the
+// same type, one errors.As or errors.Is test covers both:
+//
+// 		errors.Is(err, &apns.StatusError{Status: format.InvalidTokenStatus})
+type StatusError = format.NotificationError
+
+// The notification formats.
+type (
+	SimpleNotification   = format.SimpleNotification
+	EnhancedNotification = format.EnhancedNotification
+	Notification         = format.Notification
+)
+
+// Command is the command ID which starts every packet.
+type Command = format.Command
+
+// The command IDs.
+const (
+	SimpleNotificationCMD   = format.SimpleNotificationCMD
+	EnhancedNotificationCMD = format.EnhancedNotificationCMD
+	NotificationCMD         = format.NotificationCMD
+	NotificationErrorCMD    = format.NotificationErrorCMD
+	FeedbackCMD             = format.FeedbackCMD
+)
+
+// Status is the status code of an ErrorResponse.
+type Status = format.Status
+
+// The status codes of an ErrorResponse.
+const (
+	NoErrStatus              = format.NoErrStatus
+	ProcessingErrorsStatus   = format.ProcessingErrorsStatus
+	MissingTokenStatus       = format.MissingTokenStatus
+	MissingTopicStatus       = format.MissingTopicStatus
+	MissingPayloadStatus     = format.MissingPayloadStatus
+	InvalidTokenSizeStatus   = format.InvalidTokenSizeStatus
+	InvalidTopicSizeStatus   = format.InvalidTopicSizeStatus
+	InvalidPayloadSizeStatus = format.InvalidPayloadSizeStatus
+	InvalidTokenStatus       = format.InvalidTokenStatus
+	ShutdownStatus           = format.ShutdownStatus
+	UnknownStatus            = format.UnknownStatus
+)
@@ -1,94 +1,224 @@
-// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
-// Use of this source code is governed by a BSD-style
-// license that can be found in the LICENSE file.
-
-package apns
-
-import (
-	"crypto/tls"
-	"net"
-)
-
-var pushHosts = [2]string{
-	"gateway.push.apple.com:2195",
-	"gateway.sandbox.push.apple.com:2195",
-}
-
-var feedbackHosts = [2]string{
-	"feedback.push.apple.com:2196",
-	"feedback.sandbox.push.apple.com:2196",
-}
-
-// Environment represents an APNs production or sandbox environment
-// configuration for connections.
-//
-// From the Local and Push Notification Programming Guide:
-//
-// 		The binary interface of the production environment is available
-// 		through gateway.push.apple.com, port 2195; the binary interface of
-// 		the sandbox (development) environment is available through
-// 		gateway.sandbox.push.apple.com, port 2195. You may establish
-// 		multiple, parallel connections to the same gateway or to multiple
-// 		gateway instances.
-type Environment int8
-
-const (
-	DISTRIBUTION Environment = iota
-	SANDBOX      Environment = iota
-)
-
-// DialAPN will create a TCP connection to Apple's APNs server using
-// the certificate provided. The delay parameter tells the network
-// stack to use Nagle's algorithm to batch data in TCP packets.
-func DialAPN(cer *tls.Certificate, env Environment, delay bool) (net.Conn, error) {
-	return Dial(cer, pushHosts[env], delay)
-}
-
-// DialFeedback will create a TCP connection to Apple's feedback service.
-func DialFeedback(cer *tls.Certificate, env Environment) (net.Conn, error) {
-	return Dial(cer, feedbackHosts[env], false)
-}
-
-// Dial will connect to an APNs server provided in the host parameter.
-// Unless you plan on using a non-standard APNs server (like a mock
-// server) then it's preferable to use DialAPN or DialFeedback.
-func Dial(cer *tls.Certificate, host string, delay bool) (net.Conn, error) {
-	raddr, err := net.ResolveTCPAddr("tcp", host)
-	if err != nil {
-		return nil, err
-	}
-
-	// We want a net.TCPConn explicitly rather than just net.Conn so we can use 
-	// SetNoDelay() to control TCP packet batching.
-	tcpconn, err := net.DialTCP("tcp", nil, raddr)
-	if err != nil {
-		return nil, err
-	}
-
-	// From the Local and Push Notification Programming Guide:
-	// For optimum performance, you should batch multiple notifications in a 
-	// single transmission over the interface, either explicitly or using a 
-	// TCP/IP Nagle's algorithm.
-	tcpconn.SetNoDelay(!delay)
-
-	// We should provide the option to connect without certificates for testing 
-	// (this is convenient when one wants to setup a dummy APNs server.)
-	if cer == nil {
-		return tcpconn, nil
-	}
-
-	conf := &tls.Config{
-		Certificates: []tls.Certificate{*cer},
-	}
-	tlsconn := tls.Client(tcpconn, conf)
-
-	// From the Local and Push Notification Programming Guide:
-	// To establish a trusted provider identity, you should present this 
-	// certificate to APNs at connection time using peer-to-peer authentication
-	err = tlsconn.Handshake()
-	if err != nil {
-		return nil, err
-	}
-
-	return tlsconn, nil
-}
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"time"
+)
+
+var pushHosts = [2]string{
+	"gateway.push.apple.com:2195",
+	"gateway.sandbox.push.apple.com:2195",
+}
+
+var feedbackHosts = [2]string{
+	"feedback.push.apple.com:2196",
+	"feedback.sandbox.push.apple.com:2196",
+}
+
+// Environment represents an APNs production or sandbox environment
+// configuration for connections.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		The binary interface of the production environment is available
+// 		through gateway.push.apple.com, port 2195; the binary interface of
+// 		the sandbox (development) environment is available through
+// 		gateway.sandbox.push.apple.com, port 2195. You may establish
+// 		multiple, parallel connections to the same gateway or to multiple
+// 		gateway instances.
+type Environment int8
+
+const (
+	DISTRIBUTION Environment = iota
+	SANDBOX      Environment = iota
+)
+
+// DialAPN will create a TCP connection to Apple's APNs server using
+// the certificate provided. The delay parameter tells the network
+// stack to use Nagle's algorithm to batch data in TCP packets.
+func DialAPN(cer *tls.Certificate, env Environment, delay bool) (net.Conn, error) {
+	return defaultDialer.DialAPN(cer, env, delay)
+}
+
+// DialFeedback will create a TCP connection to Apple's feedback service.
+func DialFeedback(cer *tls.Certificate, env Environment) (net.Conn, error) {
+	return defaultDialer.DialFeedback(cer, env)
+}
+
+// Dial will connect to an APNs server provided in the host parameter.
+// Unless you plan on using a non-standard APNs server (like a mock
+// server) then it's preferable to use DialAPN or DialFeedback. The
+// connection is made as by Dialer.Dial, with the options of NewDialer.
+func Dial(cer *tls.Certificate, host string, delay bool) (net.Conn, error) {
+	return defaultDialer.Dial(cer, host, delay)
+}
+
+// DefaultDialTimeout is how long a Dialer waits for a TCP connection to
+// be established unless its Timeout says otherwise.
+const DefaultDialTimeout = 30 * time.Second
+
+// fallbackDelay is how long a dual-stack dial waits for the preferred
+// address family before racing the other (RFC 6555 "Happy Eyeballs"), so
+// a broken path in one family only costs this delay.
+const fallbackDelay = 300 * time.Millisecond
+
+// Dialer holds the options for connecting to APNs, so that each app of a
+// Manager, or each certificate, can have its own. A Dialer is safe for
+// concurrent use as long as its fields are not changed while dialing.
+type Dialer struct {
+	// Timeout bounds establishing the TCP connection. Zero means
+	// DefaultDialTimeout.
+	Timeout time.Duration
+
+	// ClientSessionCache holds TLS sessions, so that reconnecting to a
+	// gateway (for example to resend notifications after an error
+	// response) can resume the previous session instead of performing a
+	// full handshake. NewDialer sets it to a fresh cache; nil disables
+	// session resumption.
+	ClientSessionCache tls.ClientSessionCache
+
+	// RootCAs, if set, is the only set of certificate authorities trusted
+	// to sign a gateway's certificate, in place of the system roots. Use
+	// it to pin Apple's root and intermediate CAs; see LoadCAFile.
+	RootCAs *x509.CertPool
+}
+
+// NewDialer returns a Dialer with its own TLS session cache.
+func NewDialer() *Dialer {
+	return &Dialer{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+}
+
+// defaultDialer makes the connections of DialAPN, DialFeedback and Dial.
+var defaultDialer = NewDialer()
+
+// DialAPN connects to Apple's APNs gateway as the DialAPN function does.
+func (d *Dialer) DialAPN(cer *tls.Certificate, env Environment, delay bool) (net.Conn, error) {
+	return d.Dial(cer, pushHosts[env], delay)
+}
+
+// DialFeedback connects to Apple's feedback service as the DialFeedback
+// function does.
+func (d *Dialer) DialFeedback(cer *tls.Certificate, env Environment) (net.Conn, error) {
+	return d.Dial(cer, feedbackHosts[env], false)
+}
+
+// netDialer returns the dialer for the underlying TCP or Unix domain
+// socket connection, dual-stack when given a host name.
+func (d *Dialer) netDialer() *net.Dialer {
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+	return &net.Dialer{Timeout: timeout, FallbackDelay: fallbackDelay}
+}
+
+// handshakeError is a TLS handshake failure on a particular address.
+type handshakeError struct {
+	addr string
+	err  error
+}
+
+func (e *handshakeError) Error() string {
+	return e.err.Error()
+}
+
+// unixScheme prefixes host addresses which name a Unix domain socket.
+const unixScheme = "unix://"
+
+// Dial connects to the APNs server at host.
+//
+// A host of the form unix:///path/to/socket connects to a Unix domain
+// socket, such as one served by a local APNs proxy or apnserver. Such
+// connections never leave the machine and are not wrapped in TLS, so cer
+// and delay are ignored.
+//
+// The gateway host names resolve to many IPv4 and IPv6 addresses. The
+// connection is dialed dual-stack, trying every address until one accepts.
+// If the TLS handshake then fails, each remaining address is tried in turn;
+// the error from the last attempt is returned if none succeed.
+func (d *Dialer) Dial(cer *tls.Certificate, host string, delay bool) (net.Conn, error) {
+	if strings.HasPrefix(host, unixScheme) {
+		return d.netDialer().Dial("unix", strings.TrimPrefix(host, unixScheme))
+	}
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.dialAddr(cer, hostname, host, delay)
+	herr, ok := err.(*handshakeError)
+	if !ok {
+		// Either success, or the dialer has already tried every address.
+		return conn, err
+	}
+	addrs, lerr := net.LookupHost(hostname)
+	if lerr != nil {
+		return nil, herr.err
+	}
+	for _, addr := range addrs {
+		if addr == herr.addr {
+			continue
+		}
+		conn, err = d.dialAddr(cer, hostname, net.JoinHostPort(addr, port), delay)
+		if err == nil {
+			return conn, nil
+		}
+		if herr, ok = err.(*handshakeError); ok {
+			err = herr.err
+		}
+	}
+	return nil, err
+}
+
+// dialAddr connects to addr, which is either host or one of its resolved
+// addresses, and authenticates as host. Handshake failures are returned as
+// a *handshakeError.
+func (d *Dialer) dialAddr(cer *tls.Certificate, host, addr string, delay bool) (net.Conn, error) {
+	// We want a net.TCPConn explicitly rather than just net.Conn so we can use 
+	// SetNoDelay() to control TCP packet batching.
+	conn, err := d.netDialer().Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpconn := conn.(*net.TCPConn)
+
+	// From the Local and Push Notification Programming Guide:
+	// For optimum performance, you should batch multiple notifications in a 
+	// single transmission over the interface, either explicitly or using a 
+	// TCP/IP Nagle's algorithm.
+	tcpconn.SetNoDelay(!delay)
+
+	// We should provide the option to connect without certificates for testing 
+	// (this is convenient when one wants to setup a dummy APNs server.)
+	if cer == nil {
+		return tcpconn, nil
+	}
+
+	// The connection may be made to an address rather than the host name,
+	// so the name to verify must be given explicitly.
+	conf := &tls.Config{
+		Certificates:       []tls.Certificate{*cer},
+		ServerName:         host,
+		ClientSessionCache: d.ClientSessionCache,
+		RootCAs:            d.RootCAs,
+	}
+	tlsconn := tls.Client(tcpconn, conf)
+
+	// From the Local and Push Notification Programming Guide:
+	// To establish a trusted provider identity, you should present this 
+	// certificate to APNs at connection time using peer-to-peer authentication
+	err = tlsconn.Handshake()
+	if err != nil {
+		tcpconn.Close()
+		remote, _, _ := net.SplitHostPort(tcpconn.RemoteAddr().String())
+		return nil, &handshakeError{addr: remote, err: err}
+	}
+
+	return tlsconn, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+)
+
+// selfSigned returns a certificate for 127.0.0.1 signed by itself, and a
+// pool trusting it.
+func selfSigned(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// startTLS starts a mock gateway serving TLS 1.2, whose session tickets
+// arrive during the handshake, so a client resumes without reading.
+func startTLS(t *testing.T, cert *tls.Certificate) *apnstest.Server {
+	t.Helper()
+	srv := apnstest.NewUnstartedServer()
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{*cert}, MaxVersion: tls.VersionTLS12}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// resumed dials srv with d and reports whether the TLS session was resumed.
+func resumed(t *testing.T, d *apns.Dialer, cert *tls.Certificate, srv *apnstest.Server) bool {
+	t.Helper()
+	conn, err := d.Dial(cert, srv.Addr(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.(*tls.Conn).ConnectionState().DidResume
+}
+
+func TestDialerSessionCache(t *testing.T) {
+	cert, pool := selfSigned(t)
+	srv := startTLS(t, cert)
+
+	a, b := apns.NewDialer(), apns.NewDialer()
+	a.RootCAs, b.RootCAs = pool, pool
+	if resumed(t, a, cert, srv) {
+		t.Error("first dial resumed a session")
+	}
+	if !resumed(t, a, cert, srv) {
+		t.Error("second dial with the same Dialer did not resume the session")
+	}
+	if resumed(t, b, cert, srv) {
+		t.Error("a Dialer resumed the session of another")
+	}
+	b.ClientSessionCache = nil
+	if resumed(t, b, cert, srv) {
+		t.Error("a Dialer without a session cache resumed a session")
+	}
+}
+
+func TestDialerRootCAs(t *testing.T) {
+	cert, pool := selfSigned(t)
+	srv := startTLS(t, cert)
+
+	if conn, err := apns.NewDialer().Dial(cert, srv.Addr(), false); err == nil {
+		conn.Close()
+		t.Error("a Dialer trusting the system roots accepted a self-signed gateway")
+	}
+	d := apns.NewDialer()
+	d.RootCAs = pool
+	conn, err := d.Dial(cert, srv.Addr(), false)
+	if err != nil {
+		t.Fatalf("a Dialer pinned to the gateway's CA: %v", err)
+	}
+	conn.Close()
+}
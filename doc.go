@@ -5,5 +5,8 @@
 /* 
 Package apns implements the Apple Push Notification System (APNS)
 binary interface. 
+
+The packet formats, including the feedback service's tuples, are
+implemented in the format subpackage.
 */
 package apns
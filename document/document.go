@@ -0,0 +1,95 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package document decodes notification documents written in YAML or TOML
+// rather than JSON. The documents have the same fields as the JSON form
+// read by apns.MakeNotification:
+//
+// 		command: 2
+// 		device-token: beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e
+// 		identifier: 42
+// 		priority: 10
+// 		payload:
+// 		  aps:
+// 		    alert: Hello World
+// 		    badge: 1
+//
+// A device token which YAML could read as a number, such as one made up
+// only of digits, must be quoted.
+//
+// The decoders live in their own package so that the apns package does not
+// depend on the YAML and TOML libraries.
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cfilipov/apns"
+	"gopkg.in/yaml.v3"
+)
+
+// FromYAML decodes a notification from a YAML document.
+func FromYAML(data []byte) (apns.PushNotification, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return fromMap(doc)
+}
+
+// AllFromYAML decodes a notification from each document of a YAML stream,
+// where documents are separated by "---" lines.
+func AllFromYAML(data []byte) ([]apns.PushNotification, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var notifs []apns.PushNotification
+	for i := 0; ; i++ {
+		var doc map[string]interface{}
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return notifs, nil
+		}
+		if err == nil {
+			var pn apns.PushNotification
+			pn, err = fromMap(doc)
+			notifs = append(notifs, pn)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+}
+
+// FromTOML decodes a notification from a TOML document, in which the
+// payload is a table:
+//
+// 		command = 2
+// 		device-token = "beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e"
+//
+// 		[payload.aps]
+// 		alert = "Hello World"
+func FromTOML(data []byte) (apns.PushNotification, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return fromMap(doc)
+}
+
+// fromMap decodes a notification from its generic form by way of JSON, so
+// that every format is read exactly as apns.MakeNotification reads JSON.
+func fromMap(doc map[string]interface{}) (apns.PushNotification, error) {
+	if doc == nil {
+		return nil, errors.New("empty document")
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return apns.MakeNotification(data)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"context"
+	"time"
+)
+
+// drainPoll is how often, by the client's Clock, Drain checks whether
+// the client has gone idle.
+const drainPoll = 50 * time.Millisecond
+
+// Drain shuts the client down without dropping notifications. It stops
+// accepting new sends, waits for everything queued on the Notifications
+// channel to be written and for notifications set aside by an error
+// response to be sent again, waits out the error window of the last write
+// so any error responses are still delivered, and then closes the
+// connection.
+//
+// Producers must stop queuing on the Notifications channel before calling
+// Drain. If ctx is done first the connection is closed anyway and the
+// context's error is returned.
+func (c *Client) Drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+
+	for !c.idle() {
+		tick := make(chan struct{})
+		t := c.Clock.AfterFunc(drainPoll, func() { close(tick) })
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			c.Close()
+			return ctx.Err()
+		case <-tick:
+		}
+	}
+	return c.Close()
+}
+
+// idle reports whether nothing is queued, in flight, waiting to be sent
+// again, or still inside its error window.
+func (c *Client) idle() bool {
+	if len(c.notifs) > 0 {
+		return false
+	}
+	// The pipeline holds the lock while writing a batch, so holding it
+	// here also means no batch is half written.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight > 0 {
+		// Taken off the channel, waiting on the rate limiter.
+		return false
+	}
+	if len(c.resend) > 0 {
+		// Set aside by reject, to be sent again once reconnected.
+		return false
+	}
+	if c.err != nil {
+		// Nothing more will be written or answered on this connection.
+		return true
+	}
+	return len(c.pending) == 0 && c.Clock.Now().Sub(c.lastWrite) >= c.ErrorWindow
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// instantClock is a clock on which time passes only through AfterFunc,
+// which moves it ahead by the delay and then calls f at once.
+type instantClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	calls int
+}
+
+func (c *instantClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *instantClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func (c *instantClock) AfterFunc(d time.Duration, f func()) apns.Timer {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.calls++
+	c.mu.Unlock()
+	go f()
+	return stoppedTimer{}
+}
+
+func (c *instantClock) afterFuncs() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+type stoppedTimer struct{}
+
+func (stoppedTimer) Stop() bool { return false }
+
+func TestDrainWaitsForInflight(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	c := dialTest(t, srv)
+	clock := &instantClock{now: time.Now()}
+	c.Clock = clock
+	c.ErrorWindow = time.Second
+
+	// The pipeline takes the notification off the channel and then
+	// sleeps on the limiter until wake is closed.
+	limiterClock := &stoppedClock{now: time.Now(), wake: make(chan struct{})}
+	c.Limiter = apns.NewRateLimiter(1, 1)
+	c.Limiter.Clock = limiterClock
+	c.Limiter.Wait()
+	c.Notifications() <- testNotification(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(limiterClock.slept()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pipeline did not wait on the limiter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	drained := make(chan error)
+	go func() { drained <- c.Drain(context.Background()) }()
+	for clock.afterFuncs() < 100 {
+		select {
+		case err := <-drained:
+			t.Fatalf("Drain returned %v with a notification in flight", err)
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(limiterClock.wake)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return")
+	}
+	for len(srv.Received()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not receive the notification")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDrainWaitsForResends(t *testing.T) {
+	// Notification 2 is rejected with a retryable status the first time,
+	// once Drain has started, so it and those after it are sent again
+	// while draining.
+	release := make(chan struct{})
+	var once sync.Once
+	srv := apnstest.NewUnstartedServer()
+	srv.Respond = func(pn apns.PushNotification) format.Status {
+		status := format.NoErrStatus
+		if pn.(*format.Notification).Identifier == 2 {
+			once.Do(func() {
+				<-release
+				status = format.ProcessingErrorsStatus
+			})
+		}
+		return status
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c, err := apns.DialClient(func() (net.Conn, error) {
+		return net.Dial("tcp", srv.Addr())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ErrorWindow = 100 * time.Millisecond
+	c.Backoff = apns.Backoff{Initial: 10 * time.Millisecond, Max: 10 * time.Millisecond, Multiplier: 1}
+	var results []*apns.Result
+	for id := int32(1); id <= 4; id++ {
+		results = append(results, c.SendAsync(testNotification(id)))
+	}
+
+	drained := make(chan error)
+	go func() { drained <- c.Drain(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Drain did not return")
+	}
+	for i, res := range results {
+		select {
+		case <-res.Done():
+		default:
+			t.Fatalf("notification %d unresolved after Drain", i+1)
+		}
+		if err := res.Err(); err != nil {
+			t.Errorf("notification %d: got %v, want nil", i+1, err)
+		}
+	}
+	// The server hangs up after rejecting 2, before reading 3 and 4.
+	if got := len(srv.Received()); got != 5 {
+		t.Errorf("server received %d notifications, want 1 and 2, then 2, 3 and 4 sent again", got)
+	}
+	if err := c.Send(testNotification(5)); err != apns.ErrClientClosed {
+		t.Errorf("Send after Drain: got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestDrainWaitsForErrorWindow(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	c := dialTest(t, srv)
+	c.ErrorWindow = 200 * time.Millisecond
+
+	res := c.SendAsync(testNotification(1))
+	start := time.Now()
+	if err := c.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < c.ErrorWindow {
+		t.Errorf("Drain returned after %v, inside the error window", elapsed)
+	}
+	if err := waitResult(t, res); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+func TestDrainContextDone(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	c := dialTest(t, srv)
+
+	c.SendAsync(testNotification(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain: got %v, want the context's error", err)
+	}
+}
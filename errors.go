@@ -0,0 +1,98 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// ErrUnknownCommand is returned when APN data is encountered with a
+// command that is unknown.
+var ErrUnknownCommand = errors.New("Unknown command ID.")
+
+// ErrInvalidToken is returned, before anything is written, when sending a
+// notification without a device token. Tokens are parsed with
+// format.ParseToken, which also returns it for malformed tokens.
+var ErrInvalidToken = format.ErrInvalidToken
+
+// UnknwonCommandErr is the old name of ErrUnknownCommand.
+//
+// Deprecated: use ErrUnknownCommand.
+var UnknwonCommandErr = ErrUnknownCommand
+
+// ParseError is returned by ReadCommand when a packet with a known command
+// could not be decoded. The underlying error, such as io.ErrUnexpectedEOF
+// or a *format.LengthError, is available through errors.Is and errors.As.
+type ParseError struct {
+	// The command of the packet being decoded.
+	Command format.Command
+
+	// The field being read, such as "token length" or "item 2", if known.
+	Field string
+
+	// The byte offset of the field in the packet, counting the command ID.
+	// Without a field it is where reading stopped.
+	Offset int64
+
+	// Up to the last 64 bytes of the packet read before the failure.
+	Data []byte
+
+	// The packet as far as it was decoded, so that for example the
+	// identifier of a notification can be read even if its payload could
+	// not be.
+	Packet Packet
+
+	Err error
+}
+
+// maxParseContext bounds the bytes kept in ParseError.Data.
+const maxParseContext = 64
+
+// newParseError describes a failure to decode the packet read through rec.
+func newParseError(command format.Command, p Packet, rec *recordingReader, err error) *ParseError {
+	e := &ParseError{Command: command, Offset: rec.n, Data: rec.tail, Packet: p, Err: err}
+	var derr *format.DecodeError
+	if errors.As(err, &derr) {
+		e.Field, e.Offset, e.Err = derr.Field, 1+derr.Offset, derr.Err
+	}
+	return e
+}
+
+func (e *ParseError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("Parsing %s (command %d) failed at byte %d: %v", e.Command, e.Command, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("Parsing %s (command %d) failed at byte %d (%s): %v", e.Command, e.Command, e.Offset, e.Field, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Hexdump returns Data in the format of hex.Dump, for logging.
+func (e *ParseError) Hexdump() string {
+	return hex.Dump(e.Data)
+}
+
+// errorStatus returns the status of an error response for a notification
+// which err kept from being sent: the status err carries if it has one,
+// MissingTokenStatus for ErrInvalidToken, and otherwise UnknownStatus.
+func errorStatus(err error) format.Status {
+	var nerr *format.NotificationError
+	var withStatus interface{ Status() format.Status }
+	switch {
+	case errors.As(err, &nerr):
+		return nerr.Status
+	case errors.As(err, &withStatus):
+		return withStatus.Status()
+	case errors.Is(err, ErrInvalidToken):
+		return format.MissingTokenStatus
+	}
+	return format.UnknownStatus
+}
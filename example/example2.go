@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -9,10 +11,10 @@ import (
 
 var data = `{
 	"command": 2,
-	"device-token": "beefca5e",
+	"device-token": "beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e",
 	"identifier": 42,
 	"expiry": 0,
-	"priority": 50,
+	"priority": 10,
 	"payload": {
 		"aps" : {
    	    	"alert" : "Bob wants to play poker",
@@ -22,17 +24,17 @@ var data = `{
 }`
 
 func main() {
-	var notif format.Command
+	var notif format.CommandHeader
 	json.Unmarshal([]byte(data), &notif)
 
-	fmt.Printf("Command: %d\n", notif.Command)
+	fmt.Printf("Command: %d (%s)\n", notif.Command, notif.Command)
 
 	switch notif.Command {
-	case 0:
+	case format.SimpleNotificationCMD:
 		
-	case 1:
+	case format.EnhancedNotificationCMD:
 		
-	case 2:
+	case format.NotificationCMD:
 		var n format.Notification
 		json.Unmarshal([]byte(data), &n)
 		fmt.Printf("Notification: %s\n", n.String())
@@ -43,29 +45,35 @@ func main() {
 	fmt.Printf("Notification: %s\n", n.String())
 
 	sn := &format.SimpleNotification{
-		Token:   "beefca5e",
+		Token:   n.Token,
 		Payload: n.Payload,
 	}
 	fmt.Printf("Simple Notification: %s\n", sn.String())
 
+	ids := apns.NewIdentifierAllocator()
+
 	en := &format.EnhancedNotification{
-		Identifier: 1,
+		Identifier: ids.Next(),
 		Expiry:     0,
-		Token:      "beefca5e",
+		Token:      n.Token,
 		Payload:    n.Payload,
 	}
 	fmt.Printf("Enhanced Notification: %s\n", en.String())
 
 	nn := &format.Notification{
-		Identifier: 1,
+		Identifier: ids.Next(),
 		Expiry:     0,
-		Token:      "beefca5e",
+		Token:      n.Token,
 		Priority:   5,
 		Payload:    n.Payload,
 	}
 	fmt.Printf("Notification: %s\n", nn.String())
 
-	xn := apns.MakeNotification([]byte(data))
+	xn, err := apns.MakeNotification([]byte(data))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
 	fmt.Printf("Notification: %s\n", xn.String())
 
 	return
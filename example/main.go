@@ -30,31 +30,28 @@ func main() {
 
 	defer conn.Close()
 
-	// Listen for errors.
-	go func() {
-		for {
-			p, err := apns.ReadCommand(conn)
-			if err != nil {
-				fmt.Printf("\nERROR: %s\n", err)
-				os.Exit(1)
-			}
-			if p != nil {
-				fmt.Printf("\nResponse: %s\n", p)
-				os.Exit(1)
-			}
-		}
-	}()
-
-	n := apns.MakeNotification([]byte(notif))
+	n, err := apns.MakeNotification([]byte(notif))
+	if err != nil {
+		fmt.Printf("\nERROR: %s\n", err)
+		os.Exit(1)
+	}
 	fmt.Printf("Sending %s\n", n.String())
-	err := n.WriteTo(conn)
+	_, err = n.WriteTo(conn)
 	if err != nil {
 		fmt.Printf("\nERROR: %s\n", err)
 	}
 
 	// Wait for a short time before quitting to give APNs a chance to
 	// return error responses, if any.
-	time.Sleep(5000 * time.Millisecond)
+	resp, err := apns.ReadErrorResponse(conn, 5000*time.Millisecond)
+	if err != nil {
+		fmt.Printf("\nERROR: %s\n", err)
+		os.Exit(1)
+	}
+	if resp != nil {
+		fmt.Printf("\nResponse: %s\n", resp)
+		os.Exit(1)
+	}
 
 	return
 }
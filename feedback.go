@@ -0,0 +1,127 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// FeedbackConnection reads the list of devices which no longer accept
+// notifications from the feedback service.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		Once you are connected, transmission begins immediately; you do
+// 		not need to send any command to APNs. Read the stream from the
+// 		feedback service until there is no more data to read.
+type FeedbackConnection struct {
+	conn net.Conn
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewFeedbackConnection wraps an established feedback service connection
+// (see DialFeedback).
+func NewFeedbackConnection(conn net.Conn) *FeedbackConnection {
+	return &FeedbackConnection{conn: conn}
+}
+
+// DialFeedbackConnection connects to Apple's feedback service.
+func DialFeedbackConnection(cer *tls.Certificate, env Environment) (*FeedbackConnection, error) {
+	conn, err := DialFeedback(cer, env)
+	if err != nil {
+		return nil, err
+	}
+	return NewFeedbackConnection(conn), nil
+}
+
+// Receive streams feedback tuples as they are read. The channel is closed
+// when the service closes the connection, when a tuple cannot be read, or
+// when ctx is done; Err then reports why, and is nil if the stream simply
+// ended. Tuples split across several network reads are reassembled.
+func (fc *FeedbackConnection) Receive(ctx context.Context) <-chan format.Feedback {
+	ch := make(chan format.Feedback)
+	stop := make(chan struct{})
+	go func() {
+		// Unblock the pending read once the context is done.
+		select {
+		case <-ctx.Done():
+			fc.conn.SetReadDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	go func() {
+		defer close(ch)
+		defer close(stop)
+		r := bufio.NewReader(fc.conn)
+		for {
+			var fb format.Feedback
+			_, err := fb.ReadFrom(r)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				fc.setErr(err)
+				return
+			}
+			select {
+			case ch <- fb:
+			case <-ctx.Done():
+				fc.setErr(ctx.Err())
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// ReadFeedback reads feedback tuples from r until it ends and returns them
+// all. A truncated final tuple, as left by a connection that closed
+// mid-write, is dropped rather than reported as an error.
+func ReadFeedback(r io.Reader) ([]format.Feedback, error) {
+	br := bufio.NewReader(r)
+	var fbs []format.Feedback
+	for {
+		var fb format.Feedback
+		_, err := fb.ReadFrom(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fbs, nil
+		}
+		if err != nil {
+			return fbs, err
+		}
+		fbs = append(fbs, fb)
+	}
+}
+
+func (fc *FeedbackConnection) setErr(err error) {
+	fc.mu.Lock()
+	fc.err = err
+	fc.mu.Unlock()
+}
+
+// Err returns the error that ended the last Receive, if any.
+func (fc *FeedbackConnection) Err() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.err
+}
+
+// Close closes the connection to the feedback service.
+func (fc *FeedbackConnection) Close() error {
+	return fc.conn.Close()
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// FeedbackHandler acts on feedback tuples, typically by deactivating the
+// device token.
+type FeedbackHandler interface {
+	HandleFeedback(fb format.Feedback)
+}
+
+// FeedbackHandlerFunc adapts a function to the FeedbackHandler interface.
+type FeedbackHandlerFunc func(fb format.Feedback)
+
+// HandleFeedback calls f(fb).
+func (f FeedbackHandlerFunc) HandleFeedback(fb format.Feedback) {
+	f(fb)
+}
+
+// FeedbackDeduper is a FeedbackHandler which filters feedback before
+// passing it on to another handler. It drops:
+//
+// 		- Repeat reports for a token already forwarded within Window.
+// 		- Reports older than the token's last registration, as given by
+// 		  RegisteredAt.
+//
+// The second rule follows the Local and Push Notification Programming
+// Guide:
+//
+// 		You should use the timestamp to verify that the device token
+// 		hasn't been reregistered since the feedback entry was generated.
+// 		For each device that has not been reregistered, stop sending
+// 		notifications.
+type FeedbackDeduper struct {
+	// The handler which receives feedback that passes the filter.
+	Next FeedbackHandler
+
+	// How long to suppress repeat reports for a token.
+	Window time.Duration
+
+	// RegisteredAt returns when the app last registered a token with the
+	// provider, and false if it doesn't know. May be nil.
+	RegisteredAt func(token format.Token) (time.Time, bool)
+
+	// Clock measures the suppression window. If nil, SystemClock is used.
+	Clock Clock
+
+	mu     sync.Mutex
+	seen   map[format.Token]time.Time
+	pruned time.Time
+}
+
+// NewFeedbackDeduper returns a deduper forwarding to next. A struct
+// literal setting the exported fields works as well.
+func NewFeedbackDeduper(next FeedbackHandler, window time.Duration, registeredAt func(token format.Token) (time.Time, bool)) *FeedbackDeduper {
+	return &FeedbackDeduper{
+		Next:         next,
+		Window:       window,
+		RegisteredAt: registeredAt,
+		Clock:        SystemClock,
+		seen:         make(map[format.Token]time.Time),
+	}
+}
+
+// HandleFeedback implements FeedbackHandler.
+func (d *FeedbackDeduper) HandleFeedback(fb format.Feedback) {
+	if d.RegisteredAt != nil {
+		reg, ok := d.RegisteredAt(fb.Token)
+		if ok && !fb.Time().After(reg) {
+			return
+		}
+	}
+
+	clock := d.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	now := clock.Now()
+	d.mu.Lock()
+	if d.seen == nil {
+		d.seen = make(map[format.Token]time.Time)
+	}
+	if last, ok := d.seen[fb.Token]; ok && now.Sub(last) < d.Window {
+		d.mu.Unlock()
+		return
+	}
+	d.seen[fb.Token] = now
+	d.prune(now)
+	d.mu.Unlock()
+
+	d.Next.HandleFeedback(fb)
+}
+
+// prune forgets tokens whose suppression window has passed, at most once
+// per window. The caller must hold d.mu.
+func (d *FeedbackDeduper) prune(now time.Time) {
+	if now.Sub(d.pruned) < d.Window {
+		return
+	}
+	d.pruned = now
+	for key, last := range d.seen {
+		if now.Sub(last) >= d.Window {
+			delete(d.seen, key)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+func TestFeedbackDeduperLiteral(t *testing.T) {
+	var got []format.Feedback
+	d := &apns.FeedbackDeduper{
+		Next:   apns.FeedbackHandlerFunc(func(fb format.Feedback) { got = append(got, fb) }),
+		Window: time.Hour,
+	}
+	a := format.Feedback{Timestamp: 1, Token: testNotification(1).Token}
+	b := format.Feedback{Timestamp: 2, Token: testNotification(2).Token}
+	for _, fb := range []format.Feedback{a, a, b, a} {
+		d.HandleFeedback(fb)
+	}
+	if len(got) != 2 || got[0] != a || got[1] != b {
+		t.Errorf("forwarded %v, want each token once", got)
+	}
+}
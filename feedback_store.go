@@ -0,0 +1,144 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// FeedbackStore persists feedback collected from the feedback service, so
+// it survives the connection (the service forgets tuples once read) and
+// can be processed later.
+type FeedbackStore interface {
+	// Save records feedback. Only the most recent tuple for each token is
+	// kept.
+	Save(fbs ...format.Feedback) error
+
+	// List returns every stored tuple, oldest first.
+	List() ([]format.Feedback, error)
+
+	// Purge forgets the tuples for the given tokens, typically once they
+	// have been deactivated.
+	Purge(tokens ...format.Token) error
+}
+
+// FileFeedbackStore is a FeedbackStore kept in a file of JSON lines, one
+// tuple per line. The whole store is held in memory.
+type FileFeedbackStore struct {
+	mu     sync.Mutex
+	path   string
+	tuples map[format.Token]format.Feedback
+}
+
+// OpenFileFeedbackStore loads (or creates) a feedback store file.
+func OpenFileFeedbackStore(path string) (*FileFeedbackStore, error) {
+	s := &FileFeedbackStore{
+		path:   path,
+		tuples: make(map[format.Token]format.Feedback),
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fb format.Feedback
+		if err := json.Unmarshal(scanner.Bytes(), &fb); err != nil {
+			return nil, err
+		}
+		s.put(fb)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// put keeps fb if it is the newest tuple for its token. The caller must
+// hold s.mu (or own s exclusively).
+func (s *FileFeedbackStore) put(fb format.Feedback) {
+	if old, ok := s.tuples[fb.Token]; !ok || fb.Timestamp > old.Timestamp {
+		s.tuples[fb.Token] = fb
+	}
+}
+
+// Save implements FeedbackStore.
+func (s *FileFeedbackStore) Save(fbs ...format.Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, fb := range fbs {
+		if err := enc.Encode(fb); err != nil {
+			return err
+		}
+		s.put(fb)
+	}
+	return f.Sync()
+}
+
+// List implements FeedbackStore.
+func (s *FileFeedbackStore) List() ([]format.Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(), nil
+}
+
+func (s *FileFeedbackStore) list() []format.Feedback {
+	fbs := make([]format.Feedback, 0, len(s.tuples))
+	for _, fb := range s.tuples {
+		fbs = append(fbs, fb)
+	}
+	sort.Slice(fbs, func(i, j int) bool { return fbs[i].Timestamp < fbs[j].Timestamp })
+	return fbs
+}
+
+// Purge implements FeedbackStore. The file is rewritten without the purged
+// tokens, which also drops superseded tuples.
+func (s *FileFeedbackStore) Purge(tokens ...format.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, token := range tokens {
+		delete(s.tuples, token)
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, fb := range s.list() {
+		if err := enc.Encode(fb); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// HandleFeedback implements FeedbackHandler by saving the tuple, so a
+// store can be the last stage after a FeedbackDeduper. Save errors are
+// dropped; call Save directly to observe them.
+func (s *FileFeedbackStore) HandleFeedback(fb format.Feedback) {
+	s.Save(fb)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import "encoding/json"
+
+// Alert is the alert property of the aps dictionary. An alert with only a
+// Body is marshaled as a plain string; otherwise it is marshaled as a
+// dictionary containing the fields that are set.
+//
+// 		"alert" : "Hello World"
+//
+// 		"alert" : {
+// 			"title" : "Game Request",
+// 			"body" : "Bob wants to play poker",
+// 			"action-loc-key" : "PLAY"
+// 		}
+type Alert struct {
+	// A short string describing the purpose of the notification.
+	Title string `json:"title,omitempty"`
+
+	// Additional information explaining the purpose of the notification.
+	Subtitle string `json:"subtitle,omitempty"`
+
+	// The text of the alert message.
+	Body string `json:"body,omitempty"`
+
+	// The filename of an image file in the app bundle to use as the launch
+	// image when the user taps the action button.
+	LaunchImage string `json:"launch-image,omitempty"`
+
+	// A key to an alert-message string in the app's Localizable.strings
+	// file, used instead of Body.
+	LocKey string `json:"loc-key,omitempty"`
+
+	// Variable string values to appear in place of the format specifiers
+	// in LocKey.
+	LocArgs []string `json:"loc-args,omitempty"`
+
+	// A key to a title string in Localizable.strings, used instead of
+	// Title.
+	TitleLocKey string `json:"title-loc-key,omitempty"`
+
+	// Variable string values to appear in place of the format specifiers
+	// in TitleLocKey.
+	TitleLocArgs []string `json:"title-loc-args,omitempty"`
+
+	// A key to a string in Localizable.strings used as the title of the
+	// right button ("View" by default).
+	ActionLocKey string `json:"action-loc-key,omitempty"`
+}
+
+// NewLocalizedAlert returns an alert whose text is looked up on the device
+// under locKey in the app's Localizable.strings, with args substituted for
+// its format specifiers.
+func NewLocalizedAlert(locKey string, args ...string) *Alert {
+	return &Alert{LocKey: locKey, LocArgs: args}
+}
+
+// NewLocalizedTitleAlert returns an alert with a localized title and a
+// localized body.
+func NewLocalizedTitleAlert(titleLocKey string, titleArgs []string, locKey string, args ...string) *Alert {
+	return &Alert{
+		TitleLocKey:  titleLocKey,
+		TitleLocArgs: titleArgs,
+		LocKey:       locKey,
+		LocArgs:      args,
+	}
+}
+
+// SetLocalizedTitle sets the title of the alert to the localized string
+// titleLocKey, with args substituted for its format specifiers.
+func (a *Alert) SetLocalizedTitle(titleLocKey string, args ...string) *Alert {
+	a.TitleLocKey, a.TitleLocArgs = titleLocKey, args
+	return a
+}
+
+// alertDict has Alert's fields without its methods, for marshaling the
+// dictionary form.
+type alertDict Alert
+
+// isBodyOnly reports whether the alert can be sent as a plain string.
+func (a Alert) isBodyOnly() bool {
+	return a.Title == "" && a.Subtitle == "" && a.LaunchImage == "" &&
+		a.LocKey == "" && len(a.LocArgs) == 0 &&
+		a.TitleLocKey == "" && len(a.TitleLocArgs) == 0 &&
+		a.ActionLocKey == ""
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a Alert) MarshalJSON() ([]byte, error) {
+	if a.isBodyOnly() {
+		return json.Marshal(a.Body)
+	}
+	return json.Marshal(alertDict(a))
+}
+
+// UnmarshalJSON accepts either the string or the dictionary form.
+func (a *Alert) UnmarshalJSON(data []byte) error {
+	var body string
+	if err := json.Unmarshal(data, &body); err == nil {
+		*a = Alert{Body: body}
+		return nil
+	}
+	var d alertDict
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	*a = Alert(d)
+	return nil
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import "encoding/binary"
+
+// The AppendTo methods encode a packet in the same wire format as WriteTo,
+// appending it to a caller supplied buffer which can be reused between
+// packets. On error the buffer is returned unchanged. Apart from growing
+// the buffer, the only allocation is marshaling a payload, which is
+// avoided by setting RawPayload.
+
+// appendBytes appends data prefixed with its length.
+func appendBytes(dst []byte, data []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(data)))
+	return append(dst, data...)
+}
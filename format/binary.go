@@ -0,0 +1,106 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// The packet types implement encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler using the same wire format as WriteTo, so a
+// packet can be stored and later restored without an io.Writer. Unlike
+// ReadFrom, UnmarshalBinary expects the data to begin with the command ID
+// that WriteTo writes (feedback tuples have none).
+
+// MarshalBinary encodes the notification as WriteTo would write it.
+func (sn SimpleNotification) MarshalBinary() ([]byte, error) {
+	return marshalBinary(sn)
+}
+
+// UnmarshalBinary decodes a notification encoded by MarshalBinary.
+func (sn *SimpleNotification) UnmarshalBinary(data []byte) error {
+	return unmarshalCommand(SimpleNotificationCMD, sn, data)
+}
+
+// MarshalBinary encodes the notification as WriteTo would write it.
+func (en EnhancedNotification) MarshalBinary() ([]byte, error) {
+	return marshalBinary(en)
+}
+
+// UnmarshalBinary decodes a notification encoded by MarshalBinary.
+func (en *EnhancedNotification) UnmarshalBinary(data []byte) error {
+	return unmarshalCommand(EnhancedNotificationCMD, en, data)
+}
+
+// MarshalBinary encodes the notification as WriteTo would write it.
+func (n Notification) MarshalBinary() ([]byte, error) {
+	return marshalBinary(n)
+}
+
+// UnmarshalBinary decodes a notification encoded by MarshalBinary.
+func (n *Notification) UnmarshalBinary(data []byte) error {
+	return unmarshalCommand(NotificationCMD, n, data)
+}
+
+// MarshalBinary encodes the error response as WriteTo would write it.
+func (nerr NotificationError) MarshalBinary() ([]byte, error) {
+	return marshalBinary(nerr)
+}
+
+// UnmarshalBinary decodes an error response encoded by MarshalBinary.
+func (nerr *NotificationError) UnmarshalBinary(data []byte) error {
+	err := unmarshalCommand(NotificationErrorCMD, nerr, data)
+	if err != nil {
+		return err
+	}
+	nerr.Command = NotificationErrorCMD
+	return nil
+}
+
+// MarshalBinary encodes the feedback tuple as WriteTo would write it.
+func (fb Feedback) MarshalBinary() ([]byte, error) {
+	return marshalBinary(fb)
+}
+
+// UnmarshalBinary decodes a feedback tuple encoded by MarshalBinary.
+func (fb *Feedback) UnmarshalBinary(data []byte) error {
+	return unmarshalAll(fb, data)
+}
+
+func marshalBinary(p io.WriterTo) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalCommand checks the leading command ID of data before reading
+// the rest of it into p.
+func unmarshalCommand(command Command, p io.ReaderFrom, data []byte) error {
+	if len(data) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if Command(data[0]) != command {
+		return fmt.Errorf("command %d does not match expected command %d", Command(data[0]), command)
+	}
+	return unmarshalAll(p, data[1:])
+}
+
+// unmarshalAll reads data into p, which must consume all of it.
+func unmarshalAll(p io.ReaderFrom, data []byte) error {
+	r := bytes.NewReader(data)
+	_, err := p.ReadFrom(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d trailing bytes after packet", r.Len())
+	}
+	return nil
+}
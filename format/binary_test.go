@@ -0,0 +1,83 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// simpleBody encodes what follows the command ID of a simple notification.
+func simpleBody(token format.Token, payload string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, format.TokenSize)
+	b = append(b, token[:]...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// enhancedBody encodes what follows the command ID of an enhanced
+// notification.
+func enhancedBody(id, expiry uint32, token format.Token, payload string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	b = binary.BigEndian.AppendUint32(b, expiry)
+	return append(b, simpleBody(token, payload)...)
+}
+
+func TestReadFromTwice(t *testing.T) {
+	var other format.Token
+	other[0] = 0xff
+	tests := []struct {
+		name          string
+		new           func() io.ReaderFrom
+		first, second []byte
+	}{
+		{
+			name:   "simple, empty payload",
+			new:    func() io.ReaderFrom { return new(format.SimpleNotification) },
+			first:  simpleBody(testToken, `{"aps":{"badge":1}}`),
+			second: simpleBody(other, ""),
+		},
+		{
+			name:   "enhanced, empty payload",
+			new:    func() io.ReaderFrom { return new(format.EnhancedNotification) },
+			first:  enhancedBody(1, 2, testToken, `{"aps":{"badge":1}}`),
+			second: enhancedBody(3, 0, other, ""),
+		},
+		{
+			name:   "error response",
+			new:    func() io.ReaderFrom { return new(format.NotificationError) },
+			first:  []byte{byte(format.InvalidTokenStatus), 0, 0, 0, 1},
+			second: []byte{byte(format.ShutdownStatus), 0, 0, 0, 2},
+		},
+		{
+			name:   "feedback, truncated token",
+			new:    func() io.ReaderFrom { return new(format.Feedback) },
+			first:  append([]byte{0, 0, 0, 1, 0, 32}, testToken[:]...),
+			second: append([]byte{0, 0, 0, 2, 0, 32}, other[:4]...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.new()
+			if _, err := p.ReadFrom(bytes.NewReader(tt.first)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := p.ReadFrom(bytes.NewReader(tt.second))
+			want := tt.new()
+			_, wantErr := want.ReadFrom(bytes.NewReader(tt.second))
+			if (err == nil) != (wantErr == nil) {
+				t.Fatalf("decoding again: got error %v, want %v", err, wantErr)
+			}
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("decoding again gave %+v, want %+v as when decoded once", p, want)
+			}
+		})
+	}
+}
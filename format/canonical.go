@@ -0,0 +1,49 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// CanonicalPayloads makes WriteTo marshal payloads with MarshalCanonical,
+// so the same notification always encodes to the same bytes. Raw payloads
+// are still sent as given.
+var CanonicalPayloads = false
+
+// MarshalCanonical returns a stable JSON encoding of v: object keys are
+// sorted at every level (including the fields of structs such as Payload),
+// numbers keep their original text, and characters like <, > and & are
+// not escaped as encoding/json does by default. Two values which are
+// equal as JSON always produce identical bytes, which makes golden files
+// and byte-level comparisons reproducible.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	data, err := encode(v)
+	if err != nil {
+		return nil, err
+	}
+	// Decoding into generic values turns every object into a map, which
+	// the encoder writes with sorted keys.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return encode(generic)
+}
+
+// encode marshals v without HTML escaping.
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode terminates the value with a newline.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cfilipov/apns/format"
+)
+
+func TestMarshalCanonical(t *testing.T) {
+	badge := 3
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{
+			name: "nested keys sorted",
+			v:    map[string]interface{}{"b": 1, "a": map[string]interface{}{"z": true, "y": nil}},
+			want: `{"a":{"y":null,"z":true},"b":1}`,
+		},
+		{
+			name: "struct fields sorted",
+			v: format.Payload{
+				APS:    format.APS{Alert: &format.Alert{Title: "T", Body: "B"}, Badge: &badge, Sound: "default"},
+				Custom: map[string]interface{}{"acme": "x", "Zed": 1},
+			},
+			want: `{"Zed":1,"acme":"x","aps":{"alert":{"body":"B","title":"T"},"badge":3,"sound":"default"}}`,
+		},
+		{
+			name: "numbers keep their text",
+			v:    json.RawMessage(`{"big":12345678901234567890,"f":1.50,"e":1e3}`),
+			want: `{"big":12345678901234567890,"e":1e3,"f":1.50}`,
+		},
+		{
+			name: "no HTML escaping",
+			v:    map[string]string{"k": "<a & b>"},
+			want: `{"k":"<a & b>"}`,
+		},
+		{
+			name: "arrays keep their order",
+			v:    json.RawMessage(`{"l":[3,{"b":2,"a":1},1]}`),
+			want: `{"l":[3,{"a":1,"b":2},1]}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := format.MarshalCanonical(tt.v)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalCanonicalEqualValues(t *testing.T) {
+	a := json.RawMessage(`{"aps": {"badge": 1, "alert": "hi"}, "x": [1, 2]}`)
+	b := json.RawMessage(`{"x":[1,2],"aps":{"alert":"hi","badge":1}}`)
+	ca, err := format.MarshalCanonical(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := format.MarshalCanonical(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ca) != string(cb) {
+		t.Errorf("equal values marshaled to %s and %s", ca, cb)
+	}
+}
+
+func TestCanonicalPayloads(t *testing.T) {
+	defer func(old bool) { format.CanonicalPayloads = old }(format.CanonicalPayloads)
+	n := format.Notification{Token: testToken, Payload: format.JSON{"m": "<b>", "aps": map[string]interface{}{"badge": 1}}}
+	for _, tt := range []struct {
+		canonical bool
+		want      string
+	}{
+		{false, `{"aps":{"badge":1},"m":"\u003cb\u003e"}`},
+		{true, `{"aps":{"badge":1},"m":"<b>"}`},
+	} {
+		format.CanonicalPayloads = tt.canonical
+		got, err := n.MarshalPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("CanonicalPayloads %v: got %s, want %s", tt.canonical, got, tt.want)
+		}
+	}
+}
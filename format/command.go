@@ -0,0 +1,65 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command is the command ID which starts every packet and selects its
+// format. In JSON it is written as a number.
+type Command int8
+
+const (
+	SimpleNotificationCMD   Command = 0
+	EnhancedNotificationCMD Command = 1
+	NotificationCMD         Command = 2
+	NotificationErrorCMD    Command = 8
+
+	// FeedbackCMD stands for feedback tuples. The feedback service sends
+	// nothing but tuples, so they have no command ID on the wire.
+	FeedbackCMD Command = -1
+)
+
+var commandNames = map[Command]string{
+	SimpleNotificationCMD:   "simple",
+	EnhancedNotificationCMD: "enhanced",
+	NotificationCMD:         "notification",
+	NotificationErrorCMD:    "error-response",
+	FeedbackCMD:             "feedback",
+}
+
+// String returns the name of the command, such as "enhanced", or the
+// number of an unknown command.
+func (c Command) String() string {
+	if name, ok := commandNames[c]; ok {
+		return name
+	}
+	return "command " + strconv.Itoa(int(c))
+}
+
+// ParseCommand parses a command given by name, as String returns it, or by
+// number.
+func ParseCommand(s string) (Command, error) {
+	s = strings.TrimSpace(s)
+	for c, name := range commandNames {
+		if strings.EqualFold(s, name) {
+			return c, nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown command %q", s)
+	}
+	return Command(n), nil
+}
+
+// CommandHeader decodes just the command of a notification document, to
+// choose the type to decode the rest into.
+type CommandHeader struct {
+	Command Command `json:"command"`
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"fmt"
+	"io"
+)
+
+// DecodeError is returned by the ReadFrom methods of the notifications and
+// of NotificationError when a packet cannot be decoded. It names the field
+// being read; the underlying error, such as io.ErrUnexpectedEOF or a
+// *LengthError, is available through errors.Is and errors.As.
+type DecodeError struct {
+	// The field being read, such as "token length" or "item 2".
+	Field string
+
+	// Where the field starts, in bytes after the command ID.
+	Offset int64
+
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %v", e.Field, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// countingReader counts the bytes read through it, so that ReadFrom can
+// report them as io.ReaderFrom requires. It also remembers the field being
+// read for decode errors.
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	field string
+	start int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// begin records that field is read next.
+func (cr *countingReader) begin(field string) {
+	cr.at(field, cr.n)
+}
+
+// at records that field, starting at offset, is being decoded.
+func (cr *countingReader) at(field string, offset int64) {
+	cr.field, cr.start = field, offset
+}
+
+// fail wraps err in a *DecodeError for the current field. The command ID
+// has already been read, so io.EOF becomes io.ErrUnexpectedEOF.
+func (cr *countingReader) fail(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &DecodeError{Field: cr.field, Offset: cr.start, Err: err}
+}
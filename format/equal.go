@@ -0,0 +1,132 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// The Equal and Diff methods compare packets by meaning rather than by
+// encoding: the Command fields are ignored, and payloads are equal if they
+// hold the same JSON values, whether given as Payload or RawPayload and
+// whatever the order of their keys. Diff lists each difference as
+// "field: got != want", and is empty exactly when Equal is true.
+
+// Equal reports whether sn and o are the same notification.
+func (sn SimpleNotification) Equal(o SimpleNotification) bool {
+	return len(sn.Diff(o)) == 0
+}
+
+// Diff lists the differences between sn and o.
+func (sn SimpleNotification) Diff(o SimpleNotification) []string {
+	var d differ
+	d.check("device-token", sn.Token, o.Token)
+	d.checkPayload(sn.RawPayload, sn.Payload, o.RawPayload, o.Payload)
+	return d
+}
+
+// Equal reports whether en and o are the same notification.
+func (en EnhancedNotification) Equal(o EnhancedNotification) bool {
+	return len(en.Diff(o)) == 0
+}
+
+// Diff lists the differences between en and o.
+func (en EnhancedNotification) Diff(o EnhancedNotification) []string {
+	var d differ
+	d.check("identifier", en.Identifier, o.Identifier)
+	d.check("expiry", en.Expiry, o.Expiry)
+	d.check("device-token", en.Token, o.Token)
+	d.checkPayload(en.RawPayload, en.Payload, o.RawPayload, o.Payload)
+	return d
+}
+
+// Equal reports whether n and o are the same notification.
+func (n Notification) Equal(o Notification) bool {
+	return len(n.Diff(o)) == 0
+}
+
+// Diff lists the differences between n and o.
+func (n Notification) Diff(o Notification) []string {
+	var d differ
+	d.check("device-token", n.Token, o.Token)
+	d.check("identifier", n.Identifier, o.Identifier)
+	d.check("expiry", n.Expiry, o.Expiry)
+	d.check("priority", n.Priority, o.Priority)
+	d.checkPayload(n.RawPayload, n.Payload, o.RawPayload, o.Payload)
+	if len(n.Items) != len(o.Items) {
+		d.check("items", len(n.Items), len(o.Items))
+		return d
+	}
+	for i := range n.Items {
+		a, b := n.Items[i], o.Items[i]
+		d.check(fmt.Sprintf("items[%d].number", i), a.Number, b.Number)
+		if !bytes.Equal(a.Data, b.Data) {
+			d.check(fmt.Sprintf("items[%d].data", i), a.Data, b.Data)
+		}
+	}
+	return d
+}
+
+// Equal reports whether nerr and o are the same error response.
+func (nerr NotificationError) Equal(o NotificationError) bool {
+	return len(nerr.Diff(o)) == 0
+}
+
+// Diff lists the differences between nerr and o.
+func (nerr NotificationError) Diff(o NotificationError) []string {
+	var d differ
+	d.check("status", nerr.Status, o.Status)
+	d.check("identifier", nerr.Identifier, o.Identifier)
+	return d
+}
+
+// Equal reports whether fb and o are the same feedback tuple.
+func (fb Feedback) Equal(o Feedback) bool {
+	return len(fb.Diff(o)) == 0
+}
+
+// Diff lists the differences between fb and o.
+func (fb Feedback) Diff(o Feedback) []string {
+	var d differ
+	d.check("timestamp", fb.Timestamp, o.Timestamp)
+	d.check("device-token", fb.Token, o.Token)
+	return d
+}
+
+// differ collects the differences found by Diff.
+type differ []string
+
+func (d *differ) check(field string, got, want interface{}) {
+	if !reflect.DeepEqual(got, want) {
+		*d = append(*d, fmt.Sprintf("%s: %v != %v", field, got, want))
+	}
+}
+
+// checkPayload compares two payloads as decoded JSON values.
+func (d *differ) checkPayload(raw json.RawMessage, payload JSON, oraw json.RawMessage, opayload JSON) {
+	got, gotJSON := payloadValue(raw, payload)
+	want, wantJSON := payloadValue(oraw, opayload)
+	if !reflect.DeepEqual(got, want) {
+		*d = append(*d, fmt.Sprintf("payload: %s != %s", gotJSON, wantJSON))
+	}
+}
+
+// payloadValue returns the payload as sent, decoded into generic values,
+// and as text. A payload which cannot be marshaled or decoded is compared
+// by its error.
+func payloadValue(raw json.RawMessage, payload JSON) (interface{}, string) {
+	b, err := marshalPayload(raw, payload)
+	if err != nil {
+		return err.Error(), err.Error()
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err.Error(), string(b)
+	}
+	return v, string(b)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrExpiryRange is returned for an expiry date which cannot be encoded.
+var ErrExpiryRange = errors.New("expiry date must be between 1970 and 2106")
+
+// The expiry date is sent as four bytes. Read as a signed number (as the
+// int32 Expiry fields hold it) those would roll over in January 2038, so
+// the helpers below treat the bytes as unsigned instead, which reaches
+// 2106. Dates after 2038 therefore show up as negative Expiry values.
+
+// expiryFor converts a time to the encoded expiry date. The zero time
+// gives zero, meaning the notification expires immediately.
+func expiryFor(t time.Time) (int32, error) {
+	if t.IsZero() {
+		return 0, nil
+	}
+	secs := t.Unix()
+	if secs <= 0 || secs > math.MaxUint32 {
+		return 0, ErrExpiryRange
+	}
+	return int32(uint32(secs)), nil
+}
+
+// expiryTime converts an encoded expiry date to a time. Zero gives the
+// zero time.
+func expiryTime(expiry int32) time.Time {
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(uint32(expiry)), 0).UTC()
+}
+
+// ttlExpiry returns the expiry date ttl after now. A ttl of zero or less
+// gives the zero time, so APNs does not store the notification at all.
+func ttlExpiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// ExpiryAfter returns the encoded expiry date ttl after now, as the Expiry
+// fields hold it. A ttl of zero or less gives zero, so APNs does not store
+// the notification at all.
+func ExpiryAfter(now time.Time, ttl time.Duration) (int32, error) {
+	return expiryFor(ttlExpiry(now, ttl))
+}
+
+// setExpiry stores the encoded expiry date for t in expiry, leaving it
+// unchanged if t is out of range.
+func setExpiry(expiry *int32, t time.Time) error {
+	e, err := expiryFor(t)
+	if err != nil {
+		return err
+	}
+	*expiry = e
+	return nil
+}
+
+// SetExpiry sets the date after which APNs discards the notification if
+// it could not be delivered. The zero time means it expires immediately.
+func (en *EnhancedNotification) SetExpiry(t time.Time) error {
+	return setExpiry(&en.Expiry, t)
+}
+
+// SetTTL sets the expiry date to ttl from now. A ttl of zero or less means
+// the notification expires immediately.
+func (en *EnhancedNotification) SetTTL(ttl time.Duration) error {
+	return en.SetTTLAt(time.Now(), ttl)
+}
+
+// SetTTLAt sets the expiry date to ttl after now, as SetTTL does at the
+// current time. Pass the Now of the clock given to the client, such as a
+// fake one in tests.
+func (en *EnhancedNotification) SetTTLAt(now time.Time, ttl time.Duration) error {
+	return setExpiry(&en.Expiry, ttlExpiry(now, ttl))
+}
+
+// ExpiryTime returns the expiry date, or the zero time if the
+// notification expires immediately.
+func (en EnhancedNotification) ExpiryTime() time.Time {
+	return expiryTime(en.Expiry)
+}
+
+// SetExpiry sets the date after which APNs discards the notification if
+// it could not be delivered. The zero time means it expires immediately.
+func (n *Notification) SetExpiry(t time.Time) error {
+	return setExpiry(&n.Expiry, t)
+}
+
+// SetTTL sets the expiry date to ttl from now. A ttl of zero or less means
+// the notification expires immediately.
+func (n *Notification) SetTTL(ttl time.Duration) error {
+	return n.SetTTLAt(time.Now(), ttl)
+}
+
+// SetTTLAt sets the expiry date to ttl after now, as SetTTL does at the
+// current time. Pass the Now of the clock given to the client, such as a
+// fake one in tests.
+func (n *Notification) SetTTLAt(now time.Time, ttl time.Duration) error {
+	return setExpiry(&n.Expiry, ttlExpiry(now, ttl))
+}
+
+// ExpiryTime returns the expiry date, or the zero time if the
+// notification expires immediately.
+func (n Notification) ExpiryTime() time.Time {
+	return expiryTime(n.Expiry)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Feedback implements the feedback service tuple format.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		The feedback service's list is cleared after you read it. Each
+// 		time you connect to the feedback service, the information it
+// 		returns lists only the failures that have happened since you last
+// 		connected.
+//
+// Unlike the other packets, feedback tuples have no command byte; the
+// feedback service simply streams tuples until it closes the connection.
+//
+// 		{
+// 			"timestamp": 1384392391,
+// 			"time": "2013-11-14T01:26:31Z",
+// 			"device-token": "beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e"
+// 		}
+type Feedback struct {
+	// A timestamp (as a four-byte time_t value) indicating when APNs
+	// determined that the app no longer exists on the device. This value,
+	// which is in network order, represents the seconds since 12:00 midnight
+	// on January 1, 1970 UTC.
+	Timestamp uint32
+
+	// The device token.
+	Token Token
+}
+
+// Time returns the timestamp as a time.Time.
+func (fb Feedback) Time() time.Time {
+	return time.Unix(int64(fb.Timestamp), 0).UTC()
+}
+
+// TokenHex returns the device token in hexadecimal form.
+//
+// Deprecated: Use fb.Token.Hex().
+func (fb Feedback) TokenHex() string {
+	return fb.Token.Hex()
+}
+
+// feedbackJSON is the JSON form of a Feedback. The time field is only
+// informative and is ignored when unmarshaling.
+type feedbackJSON struct {
+	Timestamp uint32 `json:"timestamp"`
+	Time      string `json:"time,omitempty"`
+	Token     Token  `json:"device-token"`
+}
+
+// MarshalJSON encodes the tuple with a hex device token and a readable
+// time alongside the raw timestamp.
+func (fb Feedback) MarshalJSON() ([]byte, error) {
+	return json.Marshal(feedbackJSON{
+		Timestamp: fb.Timestamp,
+		Time:      fb.Time().Format(time.RFC3339),
+		Token:     fb.Token,
+	})
+}
+
+// UnmarshalJSON decodes the form produced by MarshalJSON.
+func (fb *Feedback) UnmarshalJSON(data []byte) error {
+	var j feedbackJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	fb.Timestamp, fb.Token = j.Timestamp, j.Token
+	return nil
+}
+
+// ReadFrom will read a single feedback tuple from an io.Reader. It returns
+// io.EOF only if the reader ended cleanly before the tuple began, and
+// io.ErrUnexpectedEOF if it ended partway through.
+func (fb *Feedback) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	defer func() { n = cr.n }()
+	r = cr
+	*fb = Feedback{}
+	err = binary.Read(r, binary.BigEndian, &(fb.Timestamp))
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+	var tokenLen uint16
+	err = binary.Read(r, binary.BigEndian, &(tokenLen))
+	if err != nil {
+		return
+	}
+	err = checkTokenSize(int(tokenLen))
+	if err != nil {
+		return
+	}
+	_, err = io.ReadFull(r, fb.Token[:])
+	return
+}
+
+// WriteTo will write a feedback tuple to an io.Writer with a single call
+// to Write.
+func (fb Feedback) WriteTo(w io.Writer) (n int64, err error) {
+	m, err := w.Write(fb.AppendTo(nil))
+	return int64(m), err
+}
+
+// AppendTo appends the feedback tuple, as WriteTo would write it, to dst.
+func (fb Feedback) AppendTo(dst []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, fb.Timestamp)
+	return appendBytes(dst, fb.Token[:])
+}
+
+func (fb Feedback) String() string {
+	return fb.Text(StringStyle)
+}
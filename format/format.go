@@ -4,15 +4,83 @@
 
 package format
 
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrReservedKey is returned when a custom payload key would clobber the
+// aps dictionary.
+var ErrReservedKey = errors.New("the aps key is reserved for the aps dictionary")
+
+// JSON is a generic notification payload.
 type JSON map[string]interface{}
 
-const (
-	SimpleNotificationCMD   int8 = 0
-	EnhancedNotificationCMD int8 = 1
-	NotificationCMD         int8 = 2
-	NotificationErrorCMD    int8 = 8
-)
+// Set stores a provider-specific key at the top level of the payload. The
+// aps key is reserved; setting it returns ErrReservedKey and leaves the
+// payload unchanged.
+func (j *JSON) Set(key string, value interface{}) error {
+	if key == "aps" {
+		return ErrReservedKey
+	}
+	if *j == nil {
+		*j = make(JSON)
+	}
+	(*j)[key] = value
+	return nil
+}
+
+// Get returns a provider-specific key from the payload. The aps key is
+// not a custom key, so it is never returned.
+func (j JSON) Get(key string) (interface{}, bool) {
+	if key == "aps" {
+		return nil, false
+	}
+	v, ok := j[key]
+	return v, ok
+}
+
+// marshalPayload returns the raw payload if there is one, and otherwise
+// marshals the generic payload.
+func marshalPayload(raw json.RawMessage, payload JSON) ([]byte, error) {
+	if raw != nil {
+		return raw, nil
+	}
+	if payload == nil {
+		// Send an empty dictionary rather than null.
+		payload = JSON{}
+	}
+	if CanonicalPayloads {
+		return MarshalCanonical(payload)
+	}
+	return json.Marshal(payload)
+}
+
+// MarshalPayload returns the payload as it is sent: RawPayload if set,
+// and otherwise Payload marshaled.
+func (sn SimpleNotification) MarshalPayload() ([]byte, error) {
+	return marshalPayload(sn.RawPayload, sn.Payload)
+}
+
+// MarshalPayload returns the payload as it is sent: RawPayload if set,
+// and otherwise Payload marshaled.
+func (en EnhancedNotification) MarshalPayload() ([]byte, error) {
+	return marshalPayload(en.RawPayload, en.Payload)
+}
+
+// MarshalPayload returns the payload as it is sent: RawPayload if set,
+// and otherwise Payload marshaled.
+func (n Notification) MarshalPayload() ([]byte, error) {
+	return marshalPayload(n.RawPayload, n.Payload)
+}
 
-type Command struct {
-	Command int8 `json:"command"`
-}
\ No newline at end of file
+// displayPayload returns the payload to show in String: the decoded raw
+// payload if there is one, and otherwise the generic payload.
+func displayPayload(raw json.RawMessage, payload JSON) JSON {
+	if raw == nil {
+		return payload
+	}
+	var j JSON
+	json.Unmarshal(raw, &j)
+	return j
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import "fmt"
+
+// ReadLimits bounds the length fields accepted when decoding packets. The
+// lengths come from the peer, so without a bound a single bad packet could
+// make the reader allocate up to 64 KB per field (or 2 GB for a frame).
+type ReadLimits struct {
+	// Maximum device token length in bytes.
+	Token int
+
+	// Maximum payload length in bytes.
+	Payload int
+
+	// Maximum frame data length of a command 2 notification in bytes.
+	Frame int
+}
+
+// Limits are the bounds enforced by every ReadFrom in this package, and
+// for the frame by Notification.WriteTo as well. The defaults allow a 32
+// byte token and a 5 KB payload (the largest Apple has documented, for
+// VoIP pushes), and a frame holding both plus the other known items.
+var Limits = ReadLimits{
+	Token:   32,
+	Payload: 5120,
+	Frame:   5*3 + 32 + 5120 + 4 + 4 + 1,
+}
+
+// LengthError is returned when a packet declares a field longer than
+// allowed by Limits.
+type LengthError struct {
+	Field  string
+	Length int
+	Max    int
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("%s length %d exceeds the maximum of %d bytes", e.Field, e.Length, e.Max)
+}
+
+// checkLength returns a *LengthError if length exceeds max.
+func checkLength(field string, length, max int) error {
+	if length > max {
+		return &LengthError{Field: field, Length: length, Max: max}
+	}
+	return nil
+}
+
+// VoIPPayloadSize is the largest payload Apple accepts for VoIP
+// notifications. To send them, raise the command 2 limit:
+//
+// 		format.PayloadSizeLimits[format.NotificationCMD] = format.VoIPPayloadSize
+const VoIPPayloadSize = 5120
+
+// PayloadSizeLimits are the largest payloads, in bytes, that WriteTo will
+// send for each command. Apple rejects anything larger with the
+// InvalidPayloadSizeStatus, so oversized payloads are refused before
+// anything is written instead.
+var PayloadSizeLimits = map[Command]int{
+	SimpleNotificationCMD:   256,
+	EnhancedNotificationCMD: 256,
+	NotificationCMD:         2048,
+}
+
+// PayloadSizeError is returned by WriteTo when a marshaled payload exceeds
+// the limit for its command.
+type PayloadSizeError struct {
+	Command Command
+	Size    int
+	Max     int
+}
+
+func (e *PayloadSizeError) Error() string {
+	return fmt.Sprintf("payload of %d bytes exceeds the %d byte limit for command %d (%s)",
+		e.Size, e.Max, e.Command, e.Status().Description())
+}
+
+// Status returns the status APNs would have responded with.
+func (e *PayloadSizeError) Status() Status {
+	return InvalidPayloadSizeStatus
+}
+
+// checkPayloadSize returns a *PayloadSizeError if payload is too large for
+// the command.
+func checkPayloadSize(command Command, payload []byte) error {
+	max, ok := PayloadSizeLimits[command]
+	if ok && len(payload) > max {
+		return &PayloadSizeError{Command: command, Size: len(payload), Max: max}
+	}
+	return nil
+}
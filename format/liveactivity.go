@@ -0,0 +1,58 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LiveActivityPushType is the apns-push-type of Live Activity updates.
+// Live Activities can only be updated through the HTTP/2 provider API,
+// with the topic returned by LiveActivityTopic.
+const LiveActivityPushType = "liveactivity"
+
+// Live Activity events.
+const (
+	LiveActivityStart  = "start"
+	LiveActivityUpdate = "update"
+	LiveActivityEnd    = "end"
+)
+
+// LiveActivityTopic returns the apns-topic for Live Activity updates of an
+// app.
+func LiveActivityTopic(bundleID string) string {
+	return bundleID + ".push-type." + LiveActivityPushType
+}
+
+// LiveActivity fills in the aps dictionary of a Live Activity update. The
+// timestamp is required so the system can discard out-of-order updates.
+func LiveActivity(event string, contentState map[string]interface{}, timestamp int64) Payload {
+	return Payload{APS: APS{
+		Event:        event,
+		ContentState: contentState,
+		Timestamp:    timestamp,
+	}}
+}
+
+// ValidateLiveActivity checks the aps dictionary of a Live Activity
+// update: the event must be known, a timestamp must be present, and start
+// and update events must carry a content state.
+func (a APS) ValidateLiveActivity() error {
+	switch a.Event {
+	case LiveActivityStart, LiveActivityUpdate, LiveActivityEnd:
+	case "":
+		return errors.New("live activity payload is missing the event")
+	default:
+		return fmt.Errorf("unknown live activity event %q", a.Event)
+	}
+	if a.Timestamp == 0 {
+		return errors.New("live activity payload is missing the timestamp")
+	}
+	if a.Event != LiveActivityEnd && a.ContentState == nil {
+		return fmt.Errorf("live activity %s event is missing the content-state", a.Event)
+	}
+	return nil
+}
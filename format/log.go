@@ -0,0 +1,147 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// LogFullTokens makes LogValue and the fmt verbs show device tokens in
+// full. By default they show only the first and last four bytes, so that
+// logs do not collect tokens which could be used to push to the devices.
+// String and Text always show the full token.
+var LogFullTokens = false
+
+// Redacted returns the token with all but its first and last four bytes
+// elided, as in "beefca5e...beefca5e".
+func (t Token) Redacted() string {
+	h := t.Hex()
+	return h[:8] + "..." + h[len(h)-8:]
+}
+
+// logToken returns the token as LogFullTokens asks for.
+func logToken(t Token) string {
+	if LogFullTokens {
+		return t.Hex()
+	}
+	return t.Redacted()
+}
+
+// expiryAttr returns the expiry date as a time, or 0 if the notification
+// expires immediately.
+func expiryAttr(expiry int32) slog.Attr {
+	if expiry == 0 {
+		return slog.Int("expiry", 0)
+	}
+	return slog.Time("expiry", expiryTime(expiry))
+}
+
+// LogValue implements slog.LogValuer, logging the notification as a group
+// of fields with the device token redacted unless LogFullTokens is set.
+func (sn SimpleNotification) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("command", SimpleNotificationCMD.String()),
+		slog.String("token", logToken(sn.Token)),
+		slog.String("payload", compactPayload(sn.RawPayload, sn.Payload)),
+	)
+}
+
+// LogValue implements slog.LogValuer, logging the notification as a group
+// of fields with the device token redacted unless LogFullTokens is set.
+func (en EnhancedNotification) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("command", EnhancedNotificationCMD.String()),
+		slog.Int64("identifier", int64(en.Identifier)),
+		expiryAttr(en.Expiry),
+		slog.String("token", logToken(en.Token)),
+		slog.String("payload", compactPayload(en.RawPayload, en.Payload)),
+	)
+}
+
+// LogValue implements slog.LogValuer, logging the notification as a group
+// of fields with the device token redacted unless LogFullTokens is set.
+// Unknown items are only counted.
+func (n Notification) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("command", NotificationCMD.String()),
+		slog.Int64("identifier", int64(n.Identifier)),
+		expiryAttr(n.Expiry),
+		slog.Int("priority", int(n.Priority)),
+		slog.String("token", logToken(n.Token)),
+		slog.String("payload", compactPayload(n.RawPayload, n.Payload)),
+	}
+	if len(n.Items) > 0 {
+		attrs = append(attrs, slog.Int("items", len(n.Items)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// LogValue implements slog.LogValuer, logging the error response as a
+// group of fields.
+func (nerr NotificationError) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("command", NotificationErrorCMD.String()),
+		slog.Int("status", int(nerr.Status)),
+		slog.String("description", nerr.Status.Description()),
+		slog.Int64("identifier", int64(nerr.Identifier)),
+	)
+}
+
+// LogValue implements slog.LogValuer, logging the feedback tuple as a
+// group of fields with the device token redacted unless LogFullTokens is
+// set.
+func (fb Feedback) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("command", FeedbackCMD.String()),
+		slog.Time("time", fb.Time()),
+		slog.String("token", logToken(fb.Token)),
+	)
+}
+
+// Format implements fmt.Formatter. The %v and %s verbs write the
+// notification in StringStyle, or in VerboseStyle with the + flag, and %q
+// writes it quoted. Unless LogFullTokens is set the device token is
+// redacted, except in the hexdump of the verbose style.
+func (sn SimpleNotification) Format(f fmt.State, verb rune) {
+	formatPacket(f, verb, sn.Text, sn.Token)
+}
+
+// Format implements fmt.Formatter, as for SimpleNotification.
+func (en EnhancedNotification) Format(f fmt.State, verb rune) {
+	formatPacket(f, verb, en.Text, en.Token)
+}
+
+// Format implements fmt.Formatter, as for SimpleNotification.
+func (n Notification) Format(f fmt.State, verb rune) {
+	formatPacket(f, verb, n.Text, n.Token)
+}
+
+// Format implements fmt.Formatter, as for SimpleNotification.
+func (fb Feedback) Format(f fmt.State, verb rune) {
+	formatPacket(f, verb, fb.Text, fb.Token)
+}
+
+// formatPacket writes the text of a packet for the verb, redacting token.
+func formatPacket(f fmt.State, verb rune, text func(Style) string, token Token) {
+	style := StringStyle
+	if f.Flag('+') {
+		style = VerboseStyle
+	}
+	s := text(style)
+	if !LogFullTokens {
+		s = strings.ReplaceAll(s, token.Hex(), token.Redacted())
+	}
+	switch verb {
+	case 'v', 's':
+		io.WriteString(f, s)
+	case 'q':
+		fmt.Fprintf(f, "%q", s)
+	default:
+		fmt.Fprintf(f, "%%!%c(%s)", verb, s)
+	}
+}
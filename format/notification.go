@@ -5,10 +5,12 @@
 package format
 
 import (
+	"bytes"
 	"encoding/binary"
-	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 )
 
 const (
@@ -19,6 +21,13 @@ const (
 	PriorityItemNumber   int8 = 5
 )
 
+// FrameItem is an item of the command 2 frame data which this package
+// does not otherwise know about, kept as is.
+type FrameItem struct {
+	Number int8   `json:"number"`
+	Data   []byte `json:"data"`
+}
+
 // New Notification Format (command 2)
 //
 // This format is a superset of the data in the enhanced notification format,
@@ -27,10 +36,10 @@ const (
 //
 // 		{
 //			"command": 2,
-// 			"device-token": "beefca5e",
+// 			"device-token": "beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e",
 // 			"identifier": 42,
 // 			"expiry": 0,
-// 			"priority": 50,
+// 			"priority": 10,
 // 			"payload": {
 // 				"aps" : {
 // 		   	    	"alert" : "Hello World",
@@ -41,13 +50,14 @@ const (
 type Notification struct {
 	// The new notification data format is specified by command 2.
 	// This field is automatically set.
-	Command int8 `json:"command"` // = 2
+	Command Command `json:"command"` // = 2
 
 	// The device token in binary form, as was registered by the device.
-	Token string `json:"device-token"`
+	Token Token `json:"device-token"`
 
 	// An arbitrary, opaque value that identifies this notification. This
-	// identifier is used for reporting errors to your server.
+	// identifier is used for reporting errors to your server. The item is
+	// left off when zero.
 	Identifier int32 `json:"identifier"`
 
 	// A UNIX epoch date expressed in seconds (UTC) that identifies when the
@@ -56,7 +66,8 @@ type Notification struct {
 	// If this value is non-zero, APNs stores the notification tries to
 	// deliver the notification at least once. Specify zero to indicate that
 	// the notification expires immediately and that APNs should not store
-	// the notification at all.
+	// the notification at all. The item is left off when zero, which APNs
+	// treats the same way.
 	Expiry int32 `json:"expiry"`
 
 	// The notification’s priority. Provide one of the following values:
@@ -69,136 +80,221 @@ type Notification struct {
 	//
 	// 		5 	The push message is sent at a time that conserves power on
 	// 			the device receiving it.
+	//
+	// The item is left off when zero, and APNs then uses 10.
 	Priority int8 `json:"priority"`
 
 	// The JSON-formatted payload. The payload must not be null-terminated.
 	Payload JSON `json:"payload"`
-}
 
-// Implement the PushNotification interface.
-func (en Notification) PushNotification() {}
+	// An already marshaled payload. If set, it is sent as is instead of
+	// Payload, avoiding a second marshal (which would also reorder keys).
+	RawPayload json.RawMessage `json:"-"`
 
-func (en Notification) ReadFrom(r io.Reader) (err error) {
-	return // TODO: Stub.
+	// Additional items, written after the known ones. Items with an
+	// unknown item number are kept here when decoding, so a notification
+	// passes through a decode and encode unchanged. See AddItem.
+	Items []FrameItem `json:"items,omitempty"`
 }
 
-func (n Notification) WriteTo(w io.Writer) (err error) {
-	token, err := hex.DecodeString(n.Token)
-	if err != nil {
-		return
-	}
-	payload, err := json.Marshal(n.Payload)
-	if err != nil {
-		return
-	}
+// AddItem adds an item to the frame data, for items this package does not
+// know about, such as experimental ones. Use the fields of the
+// notification for the known items rather than adding them again. The
+// frame must stay within Limits.Frame, which by default leaves no room
+// for added items alongside every known item and the largest payload.
+func (n *Notification) AddItem(number int8, data []byte) {
+	n.Items = append(n.Items, FrameItem{Number: number, Data: data})
+}
 
-	tokenLen := len(token)
-	payloadLen := len(payload)
-	identifierLen := 4 // 4 bytes
-	expiryLen := 4 // 4 bytes
-	priorityLen := 1 // 1 byte
+// Implement the PushNotification interface.
+func (en Notification) PushNotification() {}
 
-	// Calculate the size of the frame data.
-	// The size of the frame data is the sum of the sizes of all items. The 
-	// sum of an item is the sum of the sizes of its fields.
-	//
-	//                         | Number | Data len | Data         | 
-	// ------------------------+--------+----------+--------------+
-	// Device token            | 1 byte | 2 bytes  | 32 bytes     | 
-	// Payload                 | 1 byte | 2 bytes  | <= 256 bytes | 
-	// Notification identifier | 1 byte | 2 bytes  | 4 bytes      | 
-	// Expiration date         | 1 byte | 2 bytes  | 4 bytes      | 
-	// Priority                | 1 byte | 2 bytes  | 1 bytes      | 
-
-	frameLen := 0 +
-		1 + 2 + tokenLen + 
-		1 + 2 + payloadLen + 
-		1 + 2 + identifierLen + 
-		1 + 2 + expiryLen + 
-		1 + 2 + priorityLen
-
-	// It is not documented, but it is possible to leave off all but the 
-	// token and payload items from the frame data.
-
-	// Write Command
-	err = binary.Write(w, binary.BigEndian, NotificationCMD) // = 2
-	if err != nil {
-		return
-	}
-	err = binary.Write(w, binary.BigEndian, int32(frameLen))
+// ReadFrom will read a notification from an io.Reader into n. Note this
+// assumes a command ID has already been read and taken off the stream,
+// so a reader ending before the notification is complete results in
+// io.ErrUnexpectedEOF. The frame length is read first and then each item
+// of the frame data. Items may appear in any order, and any of them may
+// be left out. Items with an unknown item number are kept in n.Items.
+// Whatever n held before is cleared first, so n can be reused.
+func (n *Notification) ReadFrom(r io.Reader) (m int64, err error) {
+	cr := &countingReader{r: r}
+	defer func() { m = cr.n }()
+	r = cr
+	defer func() {
+		if err != nil {
+			err = cr.fail(err)
+		}
+	}()
+	*n = Notification{}
+	cr.begin("frame length")
+	var frameLen int32
+	err = binary.Read(r, binary.BigEndian, &frameLen)
 	if err != nil {
 		return
 	}
-	// Write Device Token Item
-	err = binary.Write(w, binary.BigEndian, TokenItemNumber)
-	if err != nil {
+	if frameLen < 0 {
+		err = fmt.Errorf("invalid frame length %d", frameLen)
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, int16(tokenLen))
+	err = checkLength("frame", int(frameLen), Limits.Frame)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, token)
+	cr.begin("frame")
+	frame := make([]byte, frameLen)
+	_, err = io.ReadFull(r, frame)
 	if err != nil {
 		return
 	}
-	// Write Payload Item
-	err = binary.Write(w, binary.BigEndian, PayloadItemNumber)
-	if err != nil {
-		return
+
+	items := bytes.NewReader(frame)
+	for items.Len() > 0 {
+		// Offsets in the frame follow the 4 byte frame length.
+		cr.at("item header", 4+int64(len(frame)-items.Len()))
+		var itemNumber int8
+		err = binary.Read(items, binary.BigEndian, &itemNumber)
+		if err != nil {
+			return
+		}
+		var itemLen uint16
+		err = binary.Read(items, binary.BigEndian, &itemLen)
+		if err != nil {
+			return
+		}
+		cr.field = fmt.Sprintf("item %d", itemNumber)
+		data := make([]byte, itemLen)
+		_, err = io.ReadFull(items, data)
+		if err != nil {
+			return
+		}
+		err = n.readItem(itemNumber, data)
+		if err != nil {
+			return
+		}
 	}
-	err = binary.Write(w, binary.BigEndian, int16(payloadLen))
-	if err != nil {
-		return
+	return
+}
+
+// itemLengths are the fixed lengths of the items which have one.
+var itemLengths = map[int8]int{
+	IdentifierItemNumber: 4,
+	ExpiryItemNumber:     4,
+	PriorityItemNumber:   1,
+}
+
+// readItem stores the data of a single frame item.
+func (n *Notification) readItem(itemNumber int8, data []byte) (err error) {
+	if want, ok := itemLengths[itemNumber]; ok && len(data) != want {
+		return fmt.Errorf("item %d has length %d, expected %d", itemNumber, len(data), want)
 	}
-	err = binary.Write(w, binary.BigEndian, payload)
-	if err != nil {
-		return
+	switch itemNumber {
+	case TokenItemNumber:
+		err = checkTokenSize(len(data))
+		if err != nil {
+			return
+		}
+		copy(n.Token[:], data)
+	case PayloadItemNumber:
+		err = checkLength("payload", len(data), Limits.Payload)
+		if err != nil || len(data) == 0 {
+			return
+		}
+		var payload JSON
+		err = json.Unmarshal(data, &payload)
+		if err != nil {
+			return
+		}
+		n.Payload = payload
+	case IdentifierItemNumber:
+		n.Identifier = int32(binary.BigEndian.Uint32(data))
+	case ExpiryItemNumber:
+		n.Expiry = int32(binary.BigEndian.Uint32(data))
+	case PriorityItemNumber:
+		n.Priority = int8(data[0])
+	default:
+		n.Items = append(n.Items, FrameItem{Number: itemNumber, Data: data})
 	}
-	// Write Identifier Item
-	err = binary.Write(w, binary.BigEndian, IdentifierItemNumber)
+	return
+}
+
+// WriteTo will write the entire notification to an io.Writer. The
+// notification is encoded up front and written with a single call to
+// Write, so goroutines sharing a connection never interleave their
+// notifications.
+func (n Notification) WriteTo(w io.Writer) (written int64, err error) {
+	b, err := n.AppendTo(nil)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, int16(identifierLen))
-	if err != nil {
-		return
+	m, err := w.Write(b)
+	return int64(m), err
+}
+
+// AppendTo appends the notification, as WriteTo would write it, to dst.
+func (n Notification) AppendTo(dst []byte) ([]byte, error) {
+	if n.Token.IsZero() {
+		return dst, ErrInvalidToken
 	}
-	err = binary.Write(w, binary.BigEndian, n.Identifier)
+	payload, err := marshalPayload(n.RawPayload, n.Payload)
 	if err != nil {
-		return
+		return dst, err
 	}
-	// Expiry Item
-	err = binary.Write(w, binary.BigEndian, ExpiryItemNumber)
+	err = checkPayloadSize(NotificationCMD, payload)
 	if err != nil {
-		return
+		return dst, err
 	}
-	err = binary.Write(w, binary.BigEndian, int16(expiryLen))
-	if err != nil {
-		return
+
+	// The size of the frame data is the sum of the sizes of all items. The
+	// sum of an item is the sum of the sizes of its fields.
+	//
+	//                         | Number | Data len | Data         |
+	// ------------------------+--------+----------+--------------+
+	// Device token            | 1 byte | 2 bytes  | 32 bytes     |
+	// Payload                 | 1 byte | 2 bytes  | <= 256 bytes |
+	// Notification identifier | 1 byte | 2 bytes  | 4 bytes      |
+	// Expiration date         | 1 byte | 2 bytes  | 4 bytes      |
+	// Priority                | 1 byte | 2 bytes  | 1 bytes      |
+	//
+	// It is not documented, but it is possible to leave off all but the
+	// token and payload items from the frame data. The identifier, expiry
+	// and priority items are left off when zero, letting APNs apply its
+	// defaults.
+
+	b := append(dst, byte(NotificationCMD))
+	// The frame length is filled in once the items are appended.
+	frameStart := len(b) + 4
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, byte(TokenItemNumber))
+	b = appendBytes(b, n.Token[:])
+	b = append(b, byte(PayloadItemNumber))
+	b = appendBytes(b, payload)
+	if n.Identifier != 0 {
+		b = append(b, byte(IdentifierItemNumber), 0, 4)
+		b = binary.BigEndian.AppendUint32(b, uint32(n.Identifier))
 	}
-	err = binary.Write(w, binary.BigEndian, n.Expiry)
-	if err != nil {
-		return
+	if n.Expiry != 0 {
+		b = append(b, byte(ExpiryItemNumber), 0, 4)
+		b = binary.BigEndian.AppendUint32(b, uint32(n.Expiry))
 	}
-	// Priority Item
-	err = binary.Write(w, binary.BigEndian, PriorityItemNumber)
-	if err != nil {
-		return
+	if n.Priority != 0 {
+		b = append(b, byte(PriorityItemNumber), 0, 1, byte(n.Priority))
 	}
-	err = binary.Write(w, binary.BigEndian, int16(priorityLen))
-	if err != nil {
-		return
+	for _, item := range n.Items {
+		if len(item.Data) > math.MaxUint16 {
+			return dst, fmt.Errorf("item %d data of %d bytes is too long", item.Number, len(item.Data))
+		}
+		b = append(b, byte(item.Number))
+		b = appendBytes(b, item.Data)
 	}
-	err = binary.Write(w, binary.BigEndian, n.Priority)
+	// A frame ReadFrom would refuse is not written either.
+	err = checkLength("frame", len(b)-frameStart, Limits.Frame)
 	if err != nil {
-		return
+		return dst, err
 	}
-	return
+	binary.BigEndian.PutUint32(b[frameStart-4:], uint32(len(b)-frameStart))
+	return b, nil
 }
 
 func (nn Notification) String() string {
-	nn.Command = NotificationCMD
-	n, _ := json.Marshal(nn)
-	return string(n)
+	return nn.Text(StringStyle)
 }
@@ -6,7 +6,6 @@ package format
 
 import (
 	"encoding/json"
-	"encoding/hex"
 	"encoding/binary"
 	"io"
 )
@@ -18,7 +17,7 @@ import (
 //
 // 		{
 //			"command": 1,
-// 			"device-token": "beefca5e",
+// 			"device-token": "beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e",
 // 			"identifier": 42,
 // 			"expiry": 0,
 // 			"payload": {
@@ -31,10 +30,10 @@ import (
 type EnhancedNotification struct {
 	// The first byte in the enhanced format is a command value of 1 (one).
 	// This field is automatically set.
-	Command int8 `json:"command"` // = 1
+	Command Command `json:"command"` // = 1
 
 	// The device token in binary form, as was registered by the device.
-	Token string `json:"device-token"`
+	Token Token `json:"device-token"`
 
 	// A fixed UNIX epoch date expressed in seconds (UTC) that identifies when
 	// the notification is no longer valid and can be discarded. The expiry
@@ -51,90 +50,117 @@ type EnhancedNotification struct {
 
 	// The JSON-formatted payload. The payload must not be null-terminated.
 	Payload JSON `json:"payload"`
+
+	// An already marshaled payload. If set, it is sent as is instead of
+	// Payload, avoiding a second marshal (which would also reorder keys).
+	RawPayload json.RawMessage `json:"-"`
 }
 
 // Implement the PushNotification interface.
 func (en EnhancedNotification) PushNotification() {}
 
-func (en EnhancedNotification) ReadFrom(r io.Reader) (err error) {
+// ReadFrom will read a notification from an io.Reader into en. Note this
+// assumes a command ID has already been read and taken off the stream,
+// so a reader ending before the notification is complete results in
+// io.ErrUnexpectedEOF. A payload which is not a JSON object is an error,
+// and an empty one leaves Payload nil. Whatever en held before is cleared
+// first, so en can be reused.
+func (en *EnhancedNotification) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	defer func() { n = cr.n }()
+	r = cr
+	defer func() {
+		if err != nil {
+			err = cr.fail(err)
+		}
+	}()
+	*en = EnhancedNotification{}
+	cr.begin("identifier")
 	err = binary.Read(r, binary.BigEndian, &(en.Identifier))
 	if err != nil {
 		return
 	}
+	cr.begin("expiry")
 	err = binary.Read(r, binary.BigEndian, &(en.Expiry))
 	if err != nil {
 		return
 	}
+	cr.begin("token length")
 	var tokenLen uint16
 	err = binary.Read(r, binary.BigEndian, &(tokenLen))
 	if err != nil {
 		return
 	}
-	token := make([]byte, tokenLen)
-	_, err = r.Read(token)
+	err = checkTokenSize(int(tokenLen))
 	if err != nil {
 		return
 	}
-	en.Token = string(token);
-	var payloadLen uint16
-	err = binary.Read(r, binary.BigEndian, &(payloadLen))
-	if err != nil {
-		return
-	}
-	payloadData := make([]byte, payloadLen)
-	_, err = r.Read(payloadData)
+	cr.begin("token")
+	_, err = io.ReadFull(r, en.Token[:])
 	if err != nil {
 		return
 	}
-	payload := make(map[string]interface{})
-	json.Unmarshal(payloadData, &payload)
-	en.Payload = payload
-	return
-}
-
-func (en EnhancedNotification) WriteTo(w io.Writer) (err error) {
-	// Write Command
-	err = binary.Write(w, binary.BigEndian, EnhancedNotificationCMD) // = 1
+	cr.begin("payload length")
+	var payloadLen uint16
+	err = binary.Read(r, binary.BigEndian, &(payloadLen))
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, en.Identifier)
+	err = checkLength("payload", int(payloadLen), Limits.Payload)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, en.Expiry)
+	cr.begin("payload")
+	payloadData := make([]byte, payloadLen)
+	_, err = io.ReadFull(r, payloadData)
 	if err != nil {
 		return
 	}
-	token, err := hex.DecodeString(en.Token)
-	if err != nil {
+	if payloadLen == 0 {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, uint16(len(token)))
+	var payload JSON
+	err = json.Unmarshal(payloadData, &payload)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, token)
+	en.Payload = payload
+	return
+}
+
+// WriteTo will write the entire notification to an io.Writer. The
+// notification is encoded up front and written with a single call to
+// Write, so goroutines sharing a connection never interleave their
+// notifications.
+func (en EnhancedNotification) WriteTo(w io.Writer) (n int64, err error) {
+	b, err := en.AppendTo(nil)
 	if err != nil {
 		return
 	}
-	payload, err := json.Marshal(en.Payload)
-	if err != nil {
-		return
+	m, err := w.Write(b)
+	return int64(m), err
+}
+
+// AppendTo appends the notification, as WriteTo would write it, to dst.
+func (en EnhancedNotification) AppendTo(dst []byte) ([]byte, error) {
+	if en.Token.IsZero() {
+		return dst, ErrInvalidToken
 	}
-	err = binary.Write(w, binary.BigEndian, uint16(len(payload)))
+	payload, err := marshalPayload(en.RawPayload, en.Payload)
 	if err != nil {
-		return
+		return dst, err
 	}
-	err = binary.Write(w, binary.BigEndian, payload)
+	err = checkPayloadSize(EnhancedNotificationCMD, payload)
 	if err != nil {
-		return
+		return dst, err
 	}
-	return
+	b := append(dst, byte(EnhancedNotificationCMD))
+	b = binary.BigEndian.AppendUint32(b, uint32(en.Identifier))
+	b = binary.BigEndian.AppendUint32(b, uint32(en.Expiry))
+	b = appendBytes(b, en.Token[:])
+	return appendBytes(b, payload), nil
 }
 
 func (en EnhancedNotification) String() string {
-	en.Command = EnhancedNotificationCMD
-	n, _ := json.Marshal(en)
-	return string(n)
+	return en.Text(StringStyle)
 }
@@ -6,37 +6,11 @@ package format
 
 import (
 	"encoding/binary"
-	"encoding/json"
 	"io"
 )
 
-const (
-	NoErrStatus              uint8 = 0
-	ProcessingErrorsStatus   uint8 = 1
-	MissingTokenStatus       uint8 = 2
-	MissingTopicStatus       uint8 = 3
-	MissingPayloadStatus     uint8 = 4
-	InvalidTokenSizeStatus   uint8 = 5
-	InvalidTopicSizeStatus   uint8 = 6
-	InvalidPayloadSizeStatus uint8 = 7
-	InvalidTokenStatus       uint8 = 8
-	UnknownStatus            uint8 = 255
-)
-
-var ErrorStatusCodes = map[uint8]string{
-	0:   "No errors encountered",
-	1:   "Processing Errors",
-	2:   "Missing Device Token",
-	3:   "Missing Topic",
-	4:   "Missing Payload",
-	5:   "Invalid Token Size",
-	6:   "Invalid Topic Size",
-	7:   "Invalid Payload Size",
-	8:   "Invalid Token",
-	255: "None (Unknown)",
-}
-
-// ErrorResponse implements the APNS error response format.
+// NotificationError implements the APNS error response format. The apns
+// package calls it ErrorResponse.
 //
 // From the Local and Push Notification Programming Guide:
 //
@@ -47,51 +21,56 @@ var ErrorStatusCodes = map[uint8]string{
 type NotificationError struct {
 	// The packet has a command value of 8.
 	// This field is automatically set.
-	Command int8 // = 8
+	Command Command // = 8
 
 	// A one-byte status code which identifies the type of error.
-	Status uint8
+	Status Status
 
-	// The notification identifier in the error response indicates the last
-	// notification that was successfully sent. Any notifications you sent
-	// after it have been discarded and must be resent. When you receive this
-	// status code, stop using this connection and open a new connection.
+	// The notification identifier in the error response identifies the
+	// notification that failed.
+	//
+	// For ShutdownStatus the identifier indicates the last notification that
+	// was successfully sent. Any notifications you sent after it have been
+	// discarded and must be resent. When you receive this status code, stop
+	// using this connection and open a new connection.
 	Identifier int32
 }
 
 // ReadFrom will read an error response from an io.Reader. Note this
 // assumes a command ID has already been read and taken off the
-// stream.
-func (nerr NotificationError) ReadFrom(r io.Reader) error {
-	err := binary.Read(r, binary.BigEndian, &nerr.Status)
+// stream, so a reader ending before the response is complete results in
+// io.ErrUnexpectedEOF.
+func (nerr *NotificationError) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	defer func() { n = cr.n }()
+	r = cr
+	defer func() {
+		if err != nil {
+			err = cr.fail(err)
+		}
+	}()
+	*nerr = NotificationError{Command: NotificationErrorCMD}
+	cr.begin("status")
+	err = binary.Read(r, binary.BigEndian, &nerr.Status)
 	if err != nil {
-		return err
+		return
 	}
+	cr.begin("identifier")
 	err = binary.Read(r, binary.BigEndian, &nerr.Identifier)
-	if err != nil {
-		return err
-	}
-	return nil
+	return
 }
 
-// WriteTo will write the entire error response to an io.Writer.
-func (nerr NotificationError) WriteTo(w io.Writer) error {
-	// Write Command
-	err := binary.Write(w, binary.BigEndian, nerr.Command)
-	if err != nil {
-		return err
-	}
-	// Write Status
-	err = binary.Write(w, binary.BigEndian, nerr.Status)
-	if err != nil {
-		return err
-	}
-	// Write Identifier
-	err = binary.Write(w, binary.BigEndian, nerr.Identifier)
-	if err != nil {
-		return err
-	}
-	return nil
+// WriteTo will write the entire error response to an io.Writer with a
+// single call to Write.
+func (nerr NotificationError) WriteTo(w io.Writer) (n int64, err error) {
+	m, err := w.Write(nerr.AppendTo(nil))
+	return int64(m), err
+}
+
+// AppendTo appends the error response, as WriteTo would write it, to dst.
+func (nerr NotificationError) AppendTo(dst []byte) []byte {
+	dst = append(dst, byte(NotificationErrorCMD), byte(nerr.Status))
+	return binary.BigEndian.AppendUint32(dst, uint32(nerr.Identifier))
 }
 
 // Implement the error interface.
@@ -99,8 +78,20 @@ func (nerr NotificationError) Error() string {
 	return nerr.String()
 }
 
+// Is reports whether target is an error response with the same status, so
+// callers can test for a kind of failure regardless of identifier:
+//
+// 		errors.Is(err, &format.NotificationError{Status: format.InvalidTokenStatus})
+func (nerr NotificationError) Is(target error) bool {
+	switch t := target.(type) {
+	case *NotificationError:
+		return t != nil && t.Status == nerr.Status
+	case NotificationError:
+		return t.Status == nerr.Status
+	}
+	return false
+}
+
 func (nerr NotificationError) String() string {
-	nerr.Command = NotificationErrorCMD
-	n, _ := json.Marshal(nerr)
-	return string(n)
+	return nerr.Text(StringStyle)
 }
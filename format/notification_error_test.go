@@ -0,0 +1,36 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cfilipov/apns/format"
+)
+
+func TestNotificationErrorIs(t *testing.T) {
+	err := fmt.Errorf("sending: %w", &format.NotificationError{Status: format.InvalidTokenStatus, Identifier: 7})
+	tests := []struct {
+		target error
+		want   bool
+	}{
+		{&format.NotificationError{Status: format.InvalidTokenStatus}, true},
+		{format.NotificationError{Status: format.InvalidTokenStatus, Identifier: 1}, true},
+		{&format.NotificationError{Status: format.ShutdownStatus, Identifier: 7}, false},
+		{(*format.NotificationError)(nil), false},
+		{format.ErrInvalidToken, false},
+	}
+	for _, tt := range tests {
+		if got := errors.Is(err, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%v, %#v) = %v, want %v", err, tt.target, got, tt.want)
+		}
+	}
+	var nerr *format.NotificationError
+	if !errors.As(err, &nerr) || nerr.Identifier != 7 {
+		t.Errorf("errors.As gave %v, want the wrapped error response", nerr)
+	}
+}
@@ -6,7 +6,6 @@ package format
 
 import (
 	"encoding/json"
-	"encoding/hex"
 	"encoding/binary"
 	"io"
 )
@@ -15,7 +14,7 @@ import (
 //
 // 		{
 //			"command": 0,
-// 			"device-token": "beefca5e",
+// 			"device-token": "beefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5ebeefca5e",
 // 			"payload": {
 // 				"aps" : {
 // 		   	    	"alert" : "Hello World",
@@ -26,83 +25,112 @@ import (
 type SimpleNotification struct {
 	// The first byte in the simple format is a command value of 0 (zero). 
 	// This field is automatically set.
-	Command int8 `json:"command"` // = 0
+	Command Command `json:"command"` // = 0
 
 	// The device token in binary form, as was registered by the device.
-	Token string `json:"device-token"`
+	Token Token `json:"device-token"`
 
 	// The JSON-formatted payload. The payload must not be null-terminated.
 	Payload JSON `json:"payload"`
+
+	// An already marshaled payload. If set, it is sent as is instead of
+	// Payload, avoiding a second marshal (which would also reorder keys).
+	RawPayload json.RawMessage `json:"-"`
 }
 
 // Implement the PushNotification interface.
 func (sn SimpleNotification) PushNotification() {}
 
-func (sn SimpleNotification) ReadFrom(r io.Reader) (err error) {
+// ReadFrom will read a notification from an io.Reader into sn. Note this
+// assumes a command ID has already been read and taken off the stream,
+// so a reader ending before the notification is complete results in
+// io.ErrUnexpectedEOF. A payload which is not a JSON object is an error,
+// and an empty one leaves Payload nil. Whatever sn held before is cleared
+// first, so sn can be reused.
+func (sn *SimpleNotification) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	defer func() { n = cr.n }()
+	r = cr
+	defer func() {
+		if err != nil {
+			err = cr.fail(err)
+		}
+	}()
+	*sn = SimpleNotification{}
+	cr.begin("token length")
 	var tokenLen uint16
 	err = binary.Read(r, binary.BigEndian, &(tokenLen))
 	if err != nil {
 		return
 	}
-	token := make([]byte, tokenLen)
-	_, err = r.Read(token)
+	err = checkTokenSize(int(tokenLen))
 	if err != nil {
 		return
 	}
-	sn.Token = string(token);
-	var payloadLen uint16
-	err = binary.Read(r, binary.BigEndian, &(payloadLen))
+	cr.begin("token")
+	_, err = io.ReadFull(r, sn.Token[:])
 	if err != nil {
 		return
 	}
-	payloadData := make([]byte, payloadLen)
-	_, err = r.Read(payloadData)
+	cr.begin("payload length")
+	var payloadLen uint16
+	err = binary.Read(r, binary.BigEndian, &(payloadLen))
 	if err != nil {
 		return
 	}
-	payload := make(map[string]interface{})
-	json.Unmarshal(payloadData, &payload)
-	sn.Payload = payload
-	return
-}
-
-func (sn SimpleNotification) WriteTo(w io.Writer) (err error) {
-	// Write Command
-	err = binary.Write(w, binary.BigEndian, SimpleNotificationCMD) // = 0
+	err = checkLength("payload", int(payloadLen), Limits.Payload)
 	if err != nil {
 		return
 	}
-	// Write Token
-	token, err := hex.DecodeString(sn.Token)
+	cr.begin("payload")
+	payloadData := make([]byte, payloadLen)
+	_, err = io.ReadFull(r, payloadData)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, uint16(len(token)))
-	if err != nil {
+	if payloadLen == 0 {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, token)
+	var payload JSON
+	err = json.Unmarshal(payloadData, &payload)
 	if err != nil {
 		return
 	}
-	// Write Payload
-	payload, err := json.Marshal(sn.Payload)
+	sn.Payload = payload
+	return
+}
+
+// WriteTo will write the entire notification to an io.Writer. The
+// notification is encoded up front and written with a single call to
+// Write, so goroutines sharing a connection never interleave their
+// notifications.
+func (sn SimpleNotification) WriteTo(w io.Writer) (n int64, err error) {
+	b, err := sn.AppendTo(nil)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.BigEndian, uint16(len(payload)))
+	m, err := w.Write(b)
+	return int64(m), err
+}
+
+// AppendTo appends the notification, as WriteTo would write it, to dst.
+func (sn SimpleNotification) AppendTo(dst []byte) ([]byte, error) {
+	if sn.Token.IsZero() {
+		return dst, ErrInvalidToken
+	}
+	payload, err := marshalPayload(sn.RawPayload, sn.Payload)
 	if err != nil {
-		return
+		return dst, err
 	}
-	err = binary.Write(w, binary.BigEndian, payload)
+	err = checkPayloadSize(SimpleNotificationCMD, payload)
 	if err != nil {
-		return
+		return dst, err
 	}
-	return
+	b := append(dst, byte(SimpleNotificationCMD))
+	b = appendBytes(b, sn.Token[:])
+	return appendBytes(b, payload), nil
 }
 
 func (sn SimpleNotification) String() string {
-	sn.Command = SimpleNotificationCMD
-	n, _ := json.Marshal(sn)
-	return string(n)
+	return sn.Text(StringStyle)
 }
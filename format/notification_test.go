@@ -0,0 +1,225 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// testToken is a token with every byte set, so a token left over from an
+// earlier decode shows.
+var testToken = func() format.Token {
+	var t format.Token
+	for i := range t {
+		t[i] = byte(i + 1)
+	}
+	return t
+}()
+
+// item encodes a command 2 frame item.
+func item(number int8, data []byte) []byte {
+	b := []byte{byte(number)}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// frame encodes the body of a command 2 notification, which follows the
+// command ID: the frame length and then the items.
+func frame(items ...[]byte) []byte {
+	data := bytes.Join(items, nil)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(data))), data...)
+}
+
+func uint32Item(number int8, v uint32) []byte {
+	return item(number, binary.BigEndian.AppendUint32(nil, v))
+}
+
+func TestNotificationReadFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want format.Notification
+	}{
+		{
+			name: "all items",
+			data: frame(
+				item(format.TokenItemNumber, testToken[:]),
+				item(format.PayloadItemNumber, []byte(`{"aps":{"badge":1}}`)),
+				uint32Item(format.IdentifierItemNumber, 42),
+				uint32Item(format.ExpiryItemNumber, 0xffffffff),
+				item(format.PriorityItemNumber, []byte{10}),
+			),
+			want: format.Notification{
+				Token:      testToken,
+				Payload:    format.JSON{"aps": map[string]interface{}{"badge": 1.0}},
+				Identifier: 42,
+				Expiry:     -1,
+				Priority:   10,
+			},
+		},
+		{
+			name: "any order, some left out",
+			data: frame(
+				item(format.PriorityItemNumber, []byte{5}),
+				item(format.TokenItemNumber, testToken[:]),
+			),
+			want: format.Notification{Token: testToken, Priority: 5},
+		},
+		{
+			name: "empty payload",
+			data: frame(
+				item(format.TokenItemNumber, testToken[:]),
+				item(format.PayloadItemNumber, nil),
+			),
+			want: format.Notification{Token: testToken},
+		},
+		{
+			name: "unknown items kept",
+			data: frame(
+				item(9, []byte{1, 2}),
+				item(format.TokenItemNumber, testToken[:]),
+				item(-1, nil),
+			),
+			want: format.Notification{
+				Token: testToken,
+				Items: []format.FrameItem{{Number: 9, Data: []byte{1, 2}}, {Number: -1, Data: []byte{}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n format.Notification
+			m, err := n.ReadFrom(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m != int64(len(tt.data)) {
+				t.Errorf("read %d bytes, want %d", m, len(tt.data))
+			}
+			if !reflect.DeepEqual(n, tt.want) {
+				t.Errorf("got %+v, want %+v", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationReadFromTruncated(t *testing.T) {
+	full := frame(
+		item(format.TokenItemNumber, testToken[:]),
+		uint32Item(format.IdentifierItemNumber, 42),
+	)
+	tests := []struct {
+		name  string
+		data  []byte
+		field string
+		want  error
+	}{
+		{"no frame length", full[:2], "frame length", io.ErrUnexpectedEOF},
+		{"short frame", full[:20], "frame", io.ErrUnexpectedEOF},
+		// The frame length covers only part of the identifier item.
+		{"item cut by frame length", frame(item(format.TokenItemNumber, testToken[:]), []byte{byte(format.IdentifierItemNumber), 0, 4, 0}), "item 3", io.ErrUnexpectedEOF},
+		{"item header cut by frame length", frame([]byte{byte(format.TokenItemNumber), 0}), "item header", io.ErrUnexpectedEOF},
+		{"bad item length", frame(item(format.IdentifierItemNumber, []byte{1, 2})), "item 3", nil},
+		{"negative frame length", []byte{0x80, 0, 0, 0}, "frame length", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n format.Notification
+			_, err := n.ReadFrom(bytes.NewReader(tt.data))
+			var derr *format.DecodeError
+			if !errors.As(err, &derr) {
+				t.Fatalf("got %v, want a *DecodeError", err)
+			}
+			if derr.Field != tt.field {
+				t.Errorf("error in field %q, want %q", derr.Field, tt.field)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationReadFromTwice(t *testing.T) {
+	first := frame(
+		item(format.TokenItemNumber, testToken[:]),
+		item(format.PayloadItemNumber, []byte(`{"aps":{"badge":1}}`)),
+		uint32Item(format.IdentifierItemNumber, 1),
+		item(9, []byte{1}),
+	)
+	var other format.Token
+	other[0] = 0xff
+	second := frame(
+		item(format.TokenItemNumber, other[:]),
+		item(9, []byte{2}),
+	)
+
+	n := format.Notification{RawPayload: []byte(`{"aps":{}}`)}
+	for _, data := range [][]byte{first, second} {
+		if _, err := n.ReadFrom(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := format.Notification{Token: other, Items: []format.FrameItem{{Number: 9, Data: []byte{2}}}}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("decoding again gave %+v, want %+v", n, want)
+	}
+}
+
+// TestNotificationMaxSize checks that whatever WriteTo writes at the largest
+// sizes ReadFrom reads back, and that a frame ReadFrom would refuse is not
+// written.
+func TestNotificationMaxSize(t *testing.T) {
+	defer func(max int) { format.PayloadSizeLimits[format.NotificationCMD] = max }(format.PayloadSizeLimits[format.NotificationCMD])
+	format.PayloadSizeLimits[format.NotificationCMD] = format.Limits.Payload
+
+	// payload returns a raw payload of exactly size bytes.
+	payload := func(size int) []byte {
+		prefix := `{"aps":{},"x":"`
+		return []byte(prefix + strings.Repeat("a", size-len(prefix)-2) + `"}`)
+	}
+	tests := []struct {
+		name  string
+		n     format.Notification
+		fails bool
+	}{
+		{"largest payload", format.Notification{Identifier: 1, Expiry: 2, Priority: 10, RawPayload: payload(format.Limits.Payload)}, false},
+		{"largest payload and an item", format.Notification{Identifier: 1, Expiry: 2, Priority: 10, RawPayload: payload(format.Limits.Payload), Items: []format.FrameItem{{Number: 9, Data: []byte{1}}}}, true},
+		{"smaller payload and an item", format.Notification{Identifier: 1, Expiry: 2, Priority: 10, RawPayload: payload(format.Limits.Payload - 4), Items: []format.FrameItem{{Number: 9, Data: []byte{1}}}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.n.Token = testToken
+			var buf bytes.Buffer
+			_, err := test.n.WriteTo(&buf)
+			var lerr *format.LengthError
+			if test.fails {
+				if !errors.As(err, &lerr) || buf.Len() != 0 {
+					t.Fatalf("WriteTo wrote %d bytes and returned %v, want a *LengthError", buf.Len(), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Next(1) // The command ID.
+			var got format.Notification
+			if _, err := got.ReadFrom(&buf); err != nil {
+				t.Fatalf("ReadFrom: %v", err)
+			}
+			if got.Identifier != test.n.Identifier || got.Payload["x"] == nil || !reflect.DeepEqual(got.Items, test.n.Items) {
+				t.Errorf("read back %v, want %v", got, test.n)
+			}
+		})
+	}
+}
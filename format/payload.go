@@ -0,0 +1,167 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import "encoding/json"
+
+// APS is the Apple-defined "aps" dictionary of a notification payload.
+// Unset fields are left out of the JSON.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		For each notification, compose a JSON dictionary object. This
+// 		dictionary must contain another dictionary identified by the key
+// 		aps. The aps dictionary contains one or more properties that
+// 		specify the following actions:
+//
+// 		- An alert message to display to the user
+// 		- A number to badge the app icon with
+// 		- A sound to play
+type APS struct {
+	// The alert message.
+	Alert *Alert `json:"alert,omitempty"`
+
+	// The number to display as the badge of the app icon. Use a pointer to
+	// zero to remove the badge; nil leaves the badge unchanged.
+	Badge *int `json:"badge,omitempty"`
+
+	// The name of a sound file in the app bundle, or "default".
+	Sound string `json:"sound,omitempty"`
+
+	// Set to 1 to tell the app new content is available, for background
+	// downloads and Newsstand apps.
+	ContentAvailable int `json:"content-available,omitempty"`
+
+	// The identifier of the notification category, whose actions are
+	// shown with the notification.
+	Category string `json:"category,omitempty"`
+
+	// An identifier used to group related notifications together.
+	ThreadID string `json:"thread-id,omitempty"`
+
+	// The Live Activity fields below only apply to notifications sent with
+	// the liveactivity push type; see LiveActivity.
+
+	// The action for a Live Activity: "start", "update" or "end".
+	Event string `json:"event,omitempty"`
+
+	// The updated dynamic content of a Live Activity, matching its
+	// ContentState type in the app.
+	ContentState map[string]interface{} `json:"content-state,omitempty"`
+
+	// A UNIX epoch date in seconds after which the Live Activity is
+	// considered outdated.
+	StaleDate int64 `json:"stale-date,omitempty"`
+
+	// A UNIX epoch date in seconds at which an ended Live Activity is
+	// removed from the Lock Screen.
+	DismissalDate int64 `json:"dismissal-date,omitempty"`
+
+	// A UNIX epoch date in seconds at which the update was generated. The
+	// system ignores updates older than one it has already applied.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// isEmpty reports whether no field of the dictionary is set.
+func (a APS) isEmpty() bool {
+	return a.Alert == nil && a.Badge == nil && a.Sound == "" &&
+		a.ContentAvailable == 0 && a.Category == "" && a.ThreadID == "" &&
+		a.Event == "" && a.ContentState == nil && a.StaleDate == 0 &&
+		a.DismissalDate == 0 && a.Timestamp == 0
+}
+
+// Payload is a typed notification payload: the aps dictionary plus any
+// custom keys the provider wants delivered to the app. The aps dictionary
+// is omitted when empty.
+//
+// 		{
+// 			"aps" : {
+// 				"alert" : "Hello World",
+// 				"badge" : 0
+// 			},
+// 			"msg": "Boo!"
+// 		}
+type Payload struct {
+	APS APS
+
+	// Custom keys, marshaled alongside aps at the top level. An "aps" key
+	// here is ignored.
+	Custom map[string]interface{}
+}
+
+// Set stores a custom key. The aps key is reserved for the APS field;
+// setting it returns ErrReservedKey.
+func (p *Payload) Set(key string, value interface{}) error {
+	if key == "aps" {
+		return ErrReservedKey
+	}
+	if p.Custom == nil {
+		p.Custom = make(map[string]interface{})
+	}
+	p.Custom[key] = value
+	return nil
+}
+
+// Get returns a custom key.
+func (p Payload) Get(key string) (interface{}, bool) {
+	if key == "aps" {
+		return nil, false
+	}
+	v, ok := p.Custom[key]
+	return v, ok
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Payload) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Custom)+1)
+	for k, v := range p.Custom {
+		m[k] = v
+	}
+	delete(m, "aps")
+	// An empty aps dictionary is left out entirely, so a zero Payload
+	// marshals to {} as required for Wallet pass updates.
+	if !p.APS.isEmpty() {
+		m["aps"] = p.APS
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	p.APS = APS{}
+	if aps, ok := m["aps"]; ok {
+		if err := json.Unmarshal(aps, &p.APS); err != nil {
+			return err
+		}
+		delete(m, "aps")
+	}
+	p.Custom = nil
+	if len(m) > 0 {
+		p.Custom = make(map[string]interface{}, len(m))
+		for k, raw := range m {
+			var v interface{}
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+			p.Custom[k] = v
+		}
+	}
+	return nil
+}
+
+// JSON converts the payload to the generic form held by notifications.
+func (p Payload) JSON() (JSON, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var j JSON
+	err = json.Unmarshal(data, &j)
+	return j, err
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// NotificationSchema is a JSON Schema for the JSON form of the
+// notification formats, for validating notification documents outside of
+// Go. ValidateJSON applies the same rules.
+//
+//go:embed notification.schema.json
+var NotificationSchema []byte
+
+// FieldError describes a problem with one field of a notification
+// document.
+type FieldError struct {
+	// The name of the field, such as "device-token" or "items[1].data".
+	// Empty for the document as a whole.
+	Field string
+
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// documentFields are the fields allowed in the document of each command.
+var documentFields = map[Command][]string{
+	SimpleNotificationCMD:   {"command", "device-token", "payload"},
+	EnhancedNotificationCMD: {"command", "device-token", "identifier", "expiry", "payload"},
+	NotificationCMD:         {"command", "device-token", "identifier", "expiry", "priority", "payload", "items"},
+}
+
+// ValidateJSON checks a notification document, as read by
+// apns.MakeNotification, against NotificationSchema. Rather than leaving
+// malformed fields zeroed as decoding would, it returns a ValidationError
+// with a *FieldError for every problem found.
+func ValidateJSON(data []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return ValidationError{&FieldError{Err: fmt.Errorf("not a JSON object: %v", err)}}
+	}
+	if doc == nil {
+		return ValidationError{&FieldError{Err: errors.New("not a JSON object")}}
+	}
+
+	var errs ValidationError
+	fail := func(field string, err error) {
+		errs = append(errs, &FieldError{Field: field, Err: err})
+	}
+
+	var command Command
+	raw, ok := doc["command"]
+	if !ok {
+		fail("command", errors.New("missing"))
+		return errs
+	}
+	if err := json.Unmarshal(raw, &command); err != nil || documentFields[command] == nil {
+		fail("command", fmt.Errorf("must be 0, 1 or 2, not %s", raw))
+		return errs
+	}
+
+	allowed := make(map[string]bool)
+	for _, field := range documentFields[command] {
+		allowed[field] = true
+	}
+	fields := make([]string, 0, len(doc))
+	for field := range doc {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if !allowed[field] {
+			fail(field, fmt.Errorf("not allowed for command %d", command))
+		}
+	}
+
+	if raw, ok := doc["device-token"]; !ok {
+		fail("device-token", errors.New("missing"))
+	} else {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			fail("device-token", errors.New("must be a string"))
+		} else if _, err := ParseToken(s); err != nil {
+			fail("device-token", err)
+		}
+	}
+
+	for _, field := range []string{"identifier", "expiry"} {
+		if raw, ok := doc[field]; ok && allowed[field] {
+			if err := checkInteger(raw, math.MinInt32, math.MaxInt32); err != nil {
+				fail(field, err)
+			}
+		}
+	}
+
+	if raw, ok := doc["priority"]; ok && allowed["priority"] {
+		var p int8
+		if err := json.Unmarshal(raw, &p); err != nil || (p != 0 && p != 5 && p != 10) {
+			fail("priority", fmt.Errorf("must be 0, 5 or 10, not %s", raw))
+		}
+	}
+
+	if raw, ok := doc["payload"]; !ok {
+		fail("payload", errors.New("missing"))
+	} else {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			fail("payload", errors.New("must be an object or null"))
+		} else if aps, ok := payload["aps"]; ok {
+			var a map[string]json.RawMessage
+			if err := json.Unmarshal(aps, &a); err != nil || a == nil {
+				fail("payload.aps", errors.New("must be an object"))
+			}
+		}
+	}
+
+	if raw, ok := doc["items"]; ok && allowed["items"] {
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			fail("items", errors.New("must be an array of objects"))
+		}
+		for i, item := range items {
+			checkItem(fmt.Sprintf("items[%d]", i), item, fail)
+		}
+	}
+
+	return errs.err()
+}
+
+// checkItem checks a frame item of a notification document.
+func checkItem(prefix string, item map[string]json.RawMessage, fail func(string, error)) {
+	for field := range item {
+		if field != "number" && field != "data" {
+			fail(prefix+"."+field, errors.New("not allowed"))
+		}
+	}
+	if raw, ok := item["number"]; !ok {
+		fail(prefix+".number", errors.New("missing"))
+	} else if err := checkInteger(raw, math.MinInt8, math.MaxInt8); err != nil {
+		fail(prefix+".number", err)
+	}
+	if raw, ok := item["data"]; !ok {
+		fail(prefix+".data", errors.New("missing"))
+	} else {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			fail(prefix+".data", errors.New("must be a base64 string"))
+		} else if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+			fail(prefix+".data", fmt.Errorf("must be a base64 string: %v", err))
+		}
+	}
+}
+
+// checkInteger returns an error unless raw is an integer within bounds.
+func checkInteger(raw json.RawMessage, min, max int64) error {
+	var n int64
+	if err := json.Unmarshal(raw, &n); err != nil || n < min || n > max {
+		return fmt.Errorf("must be an integer from %d to %d, not %s", min, max, raw)
+	}
+	return nil
+}
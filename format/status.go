@@ -0,0 +1,74 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+// Status is the one-byte status code of an error response, which
+// identifies the type of error.
+type Status uint8
+
+const (
+	NoErrStatus              Status = 0
+	ProcessingErrorsStatus   Status = 1
+	MissingTokenStatus       Status = 2
+	MissingTopicStatus       Status = 3
+	MissingPayloadStatus     Status = 4
+	InvalidTokenSizeStatus   Status = 5
+	InvalidTopicSizeStatus   Status = 6
+	InvalidPayloadSizeStatus Status = 7
+	InvalidTokenStatus       Status = 8
+	ShutdownStatus           Status = 10
+	UnknownStatus            Status = 255
+)
+
+var ErrorStatusCodes = map[Status]string{
+	0:   "No errors encountered",
+	1:   "Processing Errors",
+	2:   "Missing Device Token",
+	3:   "Missing Topic",
+	4:   "Missing Payload",
+	5:   "Invalid Token Size",
+	6:   "Invalid Topic Size",
+	7:   "Invalid Payload Size",
+	8:   "Invalid Token",
+	10:  "Shutdown",
+	255: "None (Unknown)",
+}
+
+// Description returns the description of the status from
+// ErrorStatusCodes. Codes missing from it are described as UnknownStatus.
+func (s Status) Description() string {
+	if desc, ok := ErrorStatusCodes[s]; ok {
+		return desc
+	}
+	return ErrorStatusCodes[UnknownStatus]
+}
+
+func (s Status) String() string {
+	return s.Description()
+}
+
+// IsRetryable reports whether a notification rejected with the status may
+// be delivered if it is sent again, on a new connection. That is so when
+// APNs itself failed: a processing error, a shutdown (which rejects
+// nothing itself, but discards the notifications sent after the one it
+// identifies) or an unknown error. Any other status means the
+// notification is malformed and would be rejected again.
+func (s Status) IsRetryable() bool {
+	switch s {
+	case ProcessingErrorsStatus, ShutdownStatus, UnknownStatus:
+		return true
+	}
+	return false
+}
+
+// ShouldDropToken reports whether the status means the device token will
+// never be accepted, so it should be removed like a token reported by the
+// feedback service. APNs rejects a well formed token with
+// InvalidTokenStatus when it is not valid for the environment or topic,
+// usually because the app was uninstalled or the token was issued for the
+// sandbox.
+func (s Status) ShouldDropToken() bool {
+	return s == InvalidTokenStatus || s == InvalidTokenSizeStatus
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Style selects how packets are described as text.
+type Style int
+
+const (
+	// JSONStyle is the JSON form, as read by apns.MakeNotification.
+	JSONStyle Style = iota
+
+	// CompactStyle is the name of the command followed by key=value fields
+	// on a single line, for logs:
+	//
+	// 		enhanced id=7 expiry=2013-11-14T01:26:31Z token=beefca5e... payload={"aps":{"alert":"Hi"}}
+	CompactStyle
+
+	// VerboseStyle is the compact line followed by a hexdump of the packet
+	// as WriteTo would send it.
+	VerboseStyle
+)
+
+// StringStyle is the Style used by the String methods of the packets.
+var StringStyle = JSONStyle
+
+var styleNames = []string{"json", "compact", "verbose"}
+
+func (s Style) String() string {
+	if s < 0 || int(s) >= len(styleNames) {
+		return fmt.Sprintf("Style(%d)", int(s))
+	}
+	return styleNames[s]
+}
+
+// MarshalText returns the name of the style.
+func (s Style) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText accepts "json", "compact" or "verbose", so a Style can be
+// used with flag.TextVar.
+func (s *Style) UnmarshalText(text []byte) error {
+	for i, name := range styleNames {
+		if string(text) == name {
+			*s = Style(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown style %q, expected json, compact or verbose", text)
+}
+
+// describe completes the compact line of a packet for style, appending a
+// hexdump of its encoding in the verbose style.
+func describe(style Style, line string, encode func() ([]byte, error)) string {
+	if style != VerboseStyle {
+		return line
+	}
+	b, err := encode()
+	if err != nil {
+		return line + "\n(cannot encode: " + err.Error() + ")"
+	}
+	return line + "\n" + strings.TrimSuffix(hex.Dump(b), "\n")
+}
+
+// compactPayload returns the payload as a single line of JSON.
+func compactPayload(raw json.RawMessage, payload JSON) string {
+	b, err := marshalPayload(raw, payload)
+	if err != nil {
+		return "(" + err.Error() + ")"
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, b) != nil {
+		return fmt.Sprintf("%q", b)
+	}
+	return buf.String()
+}
+
+// compactExpiry returns the expiry date in RFC 3339 form, or 0.
+func compactExpiry(expiry int32) string {
+	if expiry == 0 {
+		return "0"
+	}
+	return expiryTime(expiry).Format(time.RFC3339)
+}
+
+// Text describes sn in the given style.
+func (sn SimpleNotification) Text(style Style) string {
+	if style == JSONStyle {
+		sn.Command = SimpleNotificationCMD
+		sn.Payload = displayPayload(sn.RawPayload, sn.Payload)
+		n, _ := json.Marshal(sn)
+		return string(n)
+	}
+	line := fmt.Sprintf("%s token=%s payload=%s",
+		SimpleNotificationCMD, sn.Token, compactPayload(sn.RawPayload, sn.Payload))
+	return describe(style, line, func() ([]byte, error) { return sn.AppendTo(nil) })
+}
+
+// Text describes en in the given style.
+func (en EnhancedNotification) Text(style Style) string {
+	if style == JSONStyle {
+		en.Command = EnhancedNotificationCMD
+		en.Payload = displayPayload(en.RawPayload, en.Payload)
+		n, _ := json.Marshal(en)
+		return string(n)
+	}
+	line := fmt.Sprintf("%s id=%d expiry=%s token=%s payload=%s",
+		EnhancedNotificationCMD, en.Identifier, compactExpiry(en.Expiry), en.Token, compactPayload(en.RawPayload, en.Payload))
+	return describe(style, line, func() ([]byte, error) { return en.AppendTo(nil) })
+}
+
+// Text describes nn in the given style. Unknown items are listed as their
+// number and data in hexadecimal.
+func (nn Notification) Text(style Style) string {
+	if style == JSONStyle {
+		nn.Command = NotificationCMD
+		nn.Payload = displayPayload(nn.RawPayload, nn.Payload)
+		n, _ := json.Marshal(nn)
+		return string(n)
+	}
+	line := fmt.Sprintf("%s id=%d expiry=%s priority=%d token=%s payload=%s",
+		NotificationCMD, nn.Identifier, compactExpiry(nn.Expiry), nn.Priority, nn.Token, compactPayload(nn.RawPayload, nn.Payload))
+	if len(nn.Items) > 0 {
+		items := make([]string, len(nn.Items))
+		for i, item := range nn.Items {
+			items[i] = fmt.Sprintf("%d:%x", item.Number, item.Data)
+		}
+		line += " items=" + strings.Join(items, ",")
+	}
+	return describe(style, line, func() ([]byte, error) { return nn.AppendTo(nil) })
+}
+
+// Text describes nerr in the given style.
+func (nerr NotificationError) Text(style Style) string {
+	if style == JSONStyle {
+		nerr.Command = NotificationErrorCMD
+		n, _ := json.Marshal(nerr)
+		return string(n)
+	}
+	line := fmt.Sprintf("%s status=%d (%s) id=%d",
+		NotificationErrorCMD, uint8(nerr.Status), nerr.Status.Description(), nerr.Identifier)
+	return describe(style, line, func() ([]byte, error) { return nerr.AppendTo(nil), nil })
+}
+
+// Text describes fb in the given style.
+func (fb Feedback) Text(style Style) string {
+	if style == JSONStyle {
+		n, _ := json.Marshal(fb)
+		return string(n)
+	}
+	line := fmt.Sprintf("%s time=%s token=%s",
+		FeedbackCMD, time.Unix(int64(fb.Timestamp), 0).UTC().Format(time.RFC3339), fb.Token)
+	return describe(style, line, func() ([]byte, error) { return fb.AppendTo(nil), nil })
+}
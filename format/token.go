@@ -0,0 +1,104 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TokenSize is the length of a device token in bytes.
+const TokenSize = 32
+
+// ErrInvalidToken is returned for a device token which cannot be parsed,
+// and by the encoders for a notification whose token is unset. Either way
+// nothing has been written, unlike the InvalidTokenStatus error response
+// APNs returns for a well formed token it does not accept.
+var ErrInvalidToken = errors.New("invalid device token")
+
+// Token is a device token in binary form. It is written as hexadecimal
+// text, in JSON as elsewhere.
+type Token [TokenSize]byte
+
+// ParseToken parses a device token given in hexadecimal, with or without
+// spaces (the form NSData's description gives it in, including the angle
+// brackets, is accepted), or in base64. Both the standard and the URL-safe
+// base64 alphabets are accepted, with or without padding.
+func ParseToken(s string) (Token, error) {
+	var t Token
+	text := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '<' || r == '>' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(text) == hex.EncodedLen(TokenSize) {
+		if _, err := hex.Decode(t[:], []byte(text)); err == nil {
+			return t, nil
+		}
+	}
+	for _, enc := range tokenEncodings {
+		b, err := enc.DecodeString(text)
+		if err == nil && len(b) == TokenSize {
+			copy(t[:], b)
+			return t, nil
+		}
+	}
+	return Token{}, fmt.Errorf("%w %q", ErrInvalidToken, s)
+}
+
+// tokenEncodings are the base64 encodings ParseToken tries.
+var tokenEncodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.RawStdEncoding,
+	base64.URLEncoding,
+	base64.RawURLEncoding,
+}
+
+// Hex returns the token as lowercase hexadecimal.
+func (t Token) Hex() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t Token) String() string {
+	return t.Hex()
+}
+
+// IsZero reports whether the token is unset.
+func (t Token) IsZero() bool {
+	return t == Token{}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t Token) MarshalText() ([]byte, error) {
+	return []byte(t.Hex()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting any form
+// ParseToken does.
+func (t *Token) UnmarshalText(text []byte) error {
+	token, err := ParseToken(string(text))
+	if err != nil {
+		return err
+	}
+	*t = token
+	return nil
+}
+
+// checkTokenSize returns an error unless n is the length of a token.
+func checkTokenSize(n int) error {
+	err := checkLength("token", n, Limits.Token)
+	if err != nil {
+		return err
+	}
+	if n != TokenSize {
+		return fmt.Errorf("token length %d, expected %d", n, TokenSize)
+	}
+	return nil
+}
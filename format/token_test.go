@@ -0,0 +1,76 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cfilipov/apns/format"
+)
+
+func TestParseToken(t *testing.T) {
+	// The leading bytes encode to + and / in standard base64, and to - and
+	// _ in the URL-safe alphabet.
+	token := testToken
+	token[0], token[1], token[2] = 0xfb, 0xff, 0xbf
+	hex := token.Hex()
+	spaced := make([]string, 0, 8)
+	for i := 0; i < len(hex); i += 8 {
+		spaced = append(spaced, hex[i:i+8])
+	}
+
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"hex", hex},
+		{"upper case hex", strings.ToUpper(hex)},
+		{"spaced hex", strings.Join(spaced, " ")},
+		{"NSData description", "<" + strings.Join(spaced, " ") + ">"},
+		{"surrounding space", "\t" + hex + "\n"},
+		{"base64", base64.StdEncoding.EncodeToString(token[:])},
+		{"unpadded base64", base64.RawStdEncoding.EncodeToString(token[:])},
+		{"URL-safe base64", base64.URLEncoding.EncodeToString(token[:])},
+		{"unpadded URL-safe base64", base64.RawURLEncoding.EncodeToString(token[:])},
+	}
+	for _, tt := range tests {
+		got, err := format.ParseToken(tt.in)
+		if err != nil {
+			t.Errorf("%s: ParseToken(%q): %v", tt.name, tt.in, err)
+			continue
+		}
+		if got != token {
+			t.Errorf("%s: ParseToken(%q) = %v, want %v", tt.name, tt.in, got, token)
+		}
+	}
+}
+
+func TestParseTokenErrors(t *testing.T) {
+	hex := testToken.Hex()
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"short hex", hex[:62]},
+		{"long hex", hex + "00"},
+		{"odd length hex", hex[:63]},
+		{"not hex", "z" + hex[1:]},
+		{"short base64", base64.StdEncoding.EncodeToString(testToken[:31])},
+		{"long base64", base64.StdEncoding.EncodeToString(append(testToken[:], 0))},
+	}
+	for _, tt := range tests {
+		got, err := format.ParseToken(tt.in)
+		if !errors.Is(err, format.ErrInvalidToken) {
+			t.Errorf("%s: ParseToken(%q) = %v, %v; want ErrInvalidToken", tt.name, tt.in, got, err)
+		}
+		if !got.IsZero() {
+			t.Errorf("%s: ParseToken(%q) returned %v with an error, want the zero token", tt.name, tt.in, got)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ErrCannotTruncate is returned by TruncateAlert when the payload would
+// exceed the size limit even with an empty alert body.
+var ErrCannotTruncate = errors.New("payload exceeds the size limit even without alert text")
+
+// TruncateAlert shortens the alert body, whether the alert is a plain
+// string or a dictionary, so that the marshaled payload is at most max
+// bytes. Text is only ever cut between runes, never inside a multi-byte
+// UTF-8 sequence, and ellipsis (for example "…") is appended to text that
+// was cut. The payload is left unchanged if it already fits.
+func (p *Payload) TruncateAlert(max int, ellipsis string) error {
+	size := func() (int, error) {
+		data, err := json.Marshal(p)
+		return len(data), err
+	}
+	n, err := size()
+	if err != nil || n <= max {
+		return err
+	}
+	if p.APS.Alert == nil || p.APS.Alert.Body == "" {
+		return ErrCannotTruncate
+	}
+
+	body := p.APS.Alert.Body
+	// Byte offsets of every rune boundary, so the body is only cut there.
+	var cuts []int
+	for i := range body {
+		cuts = append(cuts, i)
+	}
+	var fitErr error
+	fits := func(i int) bool {
+		p.APS.Alert.Body = body[:cuts[i]] + ellipsis
+		n, err := size()
+		if err != nil {
+			fitErr = err
+		}
+		return n <= max
+	}
+	// Find the longest prefix which still fits; fits is true for short
+	// prefixes and false for long ones.
+	keep := sort.Search(len(cuts), func(i int) bool { return !fits(i) }) - 1
+	if fitErr != nil {
+		p.APS.Alert.Body = body
+		return fitErr
+	}
+	if keep < 0 {
+		p.APS.Alert.Body = body
+		return ErrCannotTruncate
+	}
+	p.APS.Alert.Body = body[:cuts[keep]] + ellipsis
+	return nil
+}
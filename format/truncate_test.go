@@ -0,0 +1,98 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/cfilipov/apns/format"
+)
+
+func TestTruncateAlert(t *testing.T) {
+	// {"aps":{"alert":""}} is 20 bytes, so a body of n bytes (none of
+	// these need escaping) marshals to 20+n.
+	const base = 20
+	tests := []struct {
+		name     string
+		body     string
+		max      int
+		ellipsis string
+		want     string
+	}{
+		{"fits", "hello", base + 5, "…", "hello"},
+		{"ascii", "hello world", base + 8, "…", "hello…"},
+		{"three byte runes", "日本語テキスト", base + 7, "…", "日…"},
+		{"four byte runes", "🎉🎉🎉", base + 9, "...", "🎉..."},
+		{"mixed widths", "aé日🎉x", base + 5, "", "aé"},
+		{"only the ellipsis fits", "日本語", base + 3, "…", "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := format.Payload{APS: format.APS{Alert: &format.Alert{Body: tt.body}}}
+			if err := p.TruncateAlert(tt.max, tt.ellipsis); err != nil {
+				t.Fatal(err)
+			}
+			got := p.APS.Alert.Body
+			if got != tt.want {
+				t.Errorf("body %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("body %q is not valid UTF-8", got)
+			}
+			data, _ := json.Marshal(p)
+			if len(data) > tt.max {
+				t.Errorf("payload %s is %d bytes, over %d", data, len(data), tt.max)
+			}
+		})
+	}
+}
+
+func TestTruncateAlertDictionary(t *testing.T) {
+	body := strings.Repeat("日本語", 50)
+	p := format.Payload{APS: format.APS{Alert: &format.Alert{Title: "Title", Body: body}}}
+	const max = 100
+	if err := p.TruncateAlert(max, "…"); err != nil {
+		t.Fatal(err)
+	}
+	got := p.APS.Alert.Body
+	if !utf8.ValidString(got) || !strings.HasPrefix(body, strings.TrimSuffix(got, "…")) {
+		t.Errorf("body %q is not a prefix of the original cut between runes", got)
+	}
+	data, _ := json.Marshal(p)
+	if len(data) > max {
+		t.Errorf("payload %s is %d bytes, over %d", data, len(data), max)
+	}
+	// One more rune would not have fit.
+	p.APS.Alert.Body = body[:len(got)-len("…")+len("日")] + "…"
+	if data, _ := json.Marshal(p); len(data) <= max {
+		t.Errorf("body %q was cut shorter than needed", got)
+	}
+	if p.APS.Alert.Title != "Title" {
+		t.Errorf("title changed to %q", p.APS.Alert.Title)
+	}
+}
+
+func TestTruncateAlertCannot(t *testing.T) {
+	tests := []struct {
+		name string
+		p    format.Payload
+	}{
+		{"ellipsis too long", format.Payload{APS: format.APS{Alert: &format.Alert{Body: "日本語"}}}},
+		{"no alert", format.Payload{APS: format.APS{Sound: "default"}, Custom: map[string]interface{}{"k": strings.Repeat("x", 40)}}},
+		{"localized alert", format.Payload{APS: format.APS{Alert: format.NewLocalizedAlert(strings.Repeat("x", 40))}}},
+	}
+	for _, tt := range tests {
+		before, _ := json.Marshal(tt.p)
+		if err := tt.p.TruncateAlert(22, "…"); err != format.ErrCannotTruncate {
+			t.Errorf("%s: got %v, want ErrCannotTruncate", tt.name, err)
+		}
+		if after, _ := json.Marshal(tt.p); string(after) != string(before) {
+			t.Errorf("%s: payload changed to %s", tt.name, after)
+		}
+	}
+}
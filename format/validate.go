@@ -0,0 +1,131 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSilentPriority is reported by Validate for a content-available only
+// notification sent with priority 10.
+//
+// From the Local and Push Notification Programming Guide:
+//
+// 		The push notification must trigger an alert, sound, or badge on
+// 		the device. It is an error to use this priority for a push that
+// 		contains only the content-available key.
+var ErrSilentPriority = errors.New("priority 10 is not allowed for a content-available only payload")
+
+// ValidationError lists every rule a notification breaks. The individual
+// errors are available through errors.Is and errors.As.
+type ValidationError []error
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e ValidationError) Unwrap() []error {
+	return e
+}
+
+// err returns e as an error, or nil if it is empty.
+func (e ValidationError) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the notification against Apple's rules without sending
+// it, and returns a ValidationError listing every violation, or nil.
+func (sn SimpleNotification) Validate() error {
+	var errs ValidationError
+	errs.checkToken(sn.Token)
+	errs.checkPayload(SimpleNotificationCMD, sn.RawPayload, sn.Payload)
+	return errs.err()
+}
+
+// Validate checks the notification against Apple's rules without sending
+// it, and returns a ValidationError listing every violation, or nil.
+func (en EnhancedNotification) Validate() error {
+	var errs ValidationError
+	errs.checkToken(en.Token)
+	errs.checkPayload(EnhancedNotificationCMD, en.RawPayload, en.Payload)
+	return errs.err()
+}
+
+// Validate checks the notification against Apple's rules without sending
+// it, and returns a ValidationError listing every violation, or nil. The
+// priority must be 5 or 10 (zero is taken as 10, as APNs does), and 10
+// is not allowed for a payload with only the content-available key.
+func (n Notification) Validate() error {
+	var errs ValidationError
+	errs.checkToken(n.Token)
+	priority := n.Priority
+	if priority == 0 {
+		priority = 10
+	}
+	if priority != 5 && priority != 10 {
+		errs = append(errs, fmt.Errorf("priority %d is neither 5 nor 10", n.Priority))
+	}
+	payload := errs.checkPayload(NotificationCMD, n.RawPayload, n.Payload)
+	if payload != nil && priority == 10 && contentAvailableOnly(payload) {
+		errs = append(errs, ErrSilentPriority)
+	}
+	return errs.err()
+}
+
+// ValidatePayload checks a payload on its own, as Validate does for a
+// notification with the given command: that it marshals to a JSON object
+// within the size limit for the command.
+func ValidatePayload(command Command, j JSON) error {
+	var errs ValidationError
+	errs.checkPayload(command, nil, j)
+	return errs.err()
+}
+
+func (e *ValidationError) checkToken(token Token) {
+	if token.IsZero() {
+		*e = append(*e, ErrInvalidToken)
+	}
+}
+
+// checkPayload checks the payload can be marshaled, is a JSON object and
+// is within the size limit. It returns the marshaled payload if it could
+// be checked.
+func (e *ValidationError) checkPayload(command Command, raw json.RawMessage, j JSON) []byte {
+	payload, err := marshalPayload(raw, j)
+	if err != nil {
+		*e = append(*e, err)
+		return nil
+	}
+	var p Payload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		*e = append(*e, fmt.Errorf("invalid payload: %v", err))
+		return nil
+	}
+	if err := checkPayloadSize(command, payload); err != nil {
+		*e = append(*e, err)
+	}
+	return payload
+}
+
+// contentAvailableOnly reports whether the aps dictionary of a payload
+// asks for a background update without alerting the user.
+func contentAvailableOnly(payload []byte) bool {
+	var p Payload
+	if json.Unmarshal(payload, &p) != nil {
+		return false
+	}
+	a := p.APS
+	return a.ContentAvailable == 1 && a.Alert == nil && a.Badge == nil && a.Sound == ""
+}
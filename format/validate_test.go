@@ -0,0 +1,90 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package format_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cfilipov/apns/format"
+)
+
+func TestValidate(t *testing.T) {
+	alert := json.RawMessage(`{"aps":{"alert":"hi"}}`)
+	silent := json.RawMessage(`{"aps":{"content-available":1}}`)
+	tooBig := json.RawMessage(`{"aps":{"alert":"` + strings.Repeat("x", 2048) + `"}}`)
+	tests := []struct {
+		name string
+		n    interface{ Validate() error }
+		// How many errors the ValidationError lists, and ones among them.
+		count int
+		is    []error
+	}{
+		{"valid", format.Notification{Token: testToken, Priority: 10, RawPayload: alert}, 0, nil},
+		{"zero priority", format.Notification{Token: testToken, RawPayload: alert}, 0, nil},
+		{"silent at 5", format.Notification{Token: testToken, Priority: 5, RawPayload: silent}, 0, nil},
+		{"no token", format.Notification{Priority: 10, RawPayload: alert}, 1, []error{format.ErrInvalidToken}},
+		{"silent at 10", format.Notification{Token: testToken, Priority: 10, RawPayload: silent}, 1, []error{format.ErrSilentPriority}},
+		{"silent at 0", format.Notification{Token: testToken, RawPayload: silent}, 1, []error{format.ErrSilentPriority}},
+		{"bad priority", format.Notification{Token: testToken, Priority: 7, RawPayload: alert}, 1, nil},
+		{"not an object", format.Notification{Token: testToken, RawPayload: json.RawMessage(`[1]`)}, 1, nil},
+		{
+			"everything wrong",
+			format.Notification{Priority: 1, RawPayload: tooBig},
+			3, []error{format.ErrInvalidToken},
+		},
+		{"simple, no token, too big", format.SimpleNotification{RawPayload: tooBig}, 2, []error{format.ErrInvalidToken}},
+		{"enhanced, valid", format.EnhancedNotification{Token: testToken, RawPayload: alert}, 0, nil},
+	}
+	for _, tt := range tests {
+		err := tt.n.Validate()
+		if tt.count == 0 {
+			if err != nil {
+				t.Errorf("%s: got %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var verr format.ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: got %v, want a ValidationError", tt.name, err)
+			continue
+		}
+		if len(verr) != tt.count {
+			t.Errorf("%s: got %d errors (%v), want %d", tt.name, len(verr), err, tt.count)
+		}
+		for _, want := range tt.is {
+			if !errors.Is(err, want) {
+				t.Errorf("%s: %v does not include %v", tt.name, err, want)
+			}
+		}
+	}
+}
+
+func TestValidationErrorAs(t *testing.T) {
+	tooBig := json.RawMessage(`{"aps":{"alert":"` + strings.Repeat("x", 2048) + `"}}`)
+	err := format.Notification{RawPayload: tooBig}.Validate()
+	var size *format.PayloadSizeError
+	if !errors.As(err, &size) {
+		t.Fatalf("%v does not include a *PayloadSizeError", err)
+	}
+	if size.Size != len(tooBig) {
+		t.Errorf("size error for %d bytes, want %d", size.Size, len(tooBig))
+	}
+	if msg := err.Error(); !strings.Contains(msg, "; ") {
+		t.Errorf("message %q does not list both errors", msg)
+	}
+}
+
+func TestValidatePayload(t *testing.T) {
+	if err := format.ValidatePayload(format.NotificationCMD, format.JSON{"aps": map[string]interface{}{"badge": 1}}); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	big := format.JSON{"aps": map[string]interface{}{"alert": strings.Repeat("x", 300)}}
+	if err := format.ValidatePayload(format.EnhancedNotificationCMD, big); err == nil {
+		t.Error("a 300 byte alert fit the enhanced format's limit")
+	}
+}
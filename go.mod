@@ -0,0 +1,10 @@
+module github.com/cfilipov/apns
+
+go 1.24
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.22
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+)
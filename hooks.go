@@ -0,0 +1,92 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"io"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// Hooks are callbacks a Client runs at points in the send path, for
+// logging, metrics, or rewriting notifications. Any hook may be nil.
+type Hooks struct {
+	// BeforeSend runs before a notification is written and may return a
+	// modified notification. Returning an error stops the notification
+	// from being sent; Send and SendAsync return that error, and the
+	// pipeline reports it on the Errors channel.
+	BeforeSend func(pn PushNotification) (PushNotification, error)
+
+	// AfterWrite runs after a notification has been written, with the
+	// write error if there was one.
+	AfterWrite func(pn PushNotification, err error)
+
+	// OnError runs for every error response read from the connection.
+	OnError func(nerr *format.NotificationError)
+
+	// OnReconnect runs after each reconnection attempt, with the dial
+	// error if the attempt failed. Attempts are counted from zero.
+	OnReconnect func(attempt int, err error)
+
+	// OnStateChange runs whenever the connection is lost, a reconnection
+	// attempt starts, or the client is connected again. Watching for
+	// repeated Disconnected events is a way to alert on a flapping
+	// connection.
+	OnStateChange func(ev StateEvent)
+}
+
+// Use registers hooks with the client. Hooks registered by separate calls
+// all run, in the order they were registered. Use is not safe to call
+// concurrently with sending.
+func (c *Client) Use(h Hooks) {
+	c.hooks = append(c.hooks, h)
+}
+
+// prepare gets a notification ready to send by giving it an identifier
+// and running the BeforeSend hooks. A notification without a device token
+// is refused with ErrInvalidToken before it is spooled or rate limited.
+func (c *Client) prepare(pn PushNotification) (PushNotification, error) {
+	pn = c.assign(pn)
+	for _, h := range c.hooks {
+		if h.BeforeSend == nil {
+			continue
+		}
+		var err error
+		if pn, err = h.BeforeSend(pn); err != nil {
+			return nil, err
+		}
+	}
+	if notificationToken(pn).IsZero() {
+		return nil, ErrInvalidToken
+	}
+	return pn, nil
+}
+
+// write writes a notification and runs the AfterWrite hooks.
+func (c *Client) write(w io.Writer, pn PushNotification) error {
+	_, err := pn.WriteTo(w)
+	for _, h := range c.hooks {
+		if h.AfterWrite != nil {
+			h.AfterWrite(pn, err)
+		}
+	}
+	return err
+}
+
+func (c *Client) onError(nerr *format.NotificationError) {
+	for _, h := range c.hooks {
+		if h.OnError != nil {
+			h.OnError(nerr)
+		}
+	}
+}
+
+func (c *Client) onReconnect(attempt int, err error) {
+	for _, h := range c.hooks {
+		if h.OnReconnect != nil {
+			h.OnReconnect(attempt, err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// Hosts of the HTTP/2 provider API, which replaces the binary interface.
+const (
+	HTTP2Production  = "https://api.push.apple.com"
+	HTTP2Development = "https://api.sandbox.push.apple.com"
+)
+
+// NewHTTP2Request converts a binary interface notification into a request
+// to the HTTP/2 provider API at host (HTTP2Production or
+// HTTP2Development), for sending with an http.Client authenticated the
+// same way as a binary connection. The fields map to the request as
+// follows:
+//
+// 		Token       the :path, /3/device/<token>
+// 		Payload     the body
+// 		Identifier  apns-id, as the UUID 00000000-0000-0000-0000-0000xxxxxxxx
+// 		            holding the identifier in hexadecimal (when not zero)
+// 		Expiry      apns-expiration (command 1 and 2 only)
+// 		Priority    apns-priority (when not zero), 5 for a background push
+//
+// The apns-push-type is worked out from the payload: "liveactivity" for a
+// Live Activity update (which is validated), "background" for a payload
+// with only the content-available key, and otherwise "alert". A payload
+// without an aps dictionary, such as a Wallet pass update, is sent
+// without the header. Apple refuses background pushes with priority 10,
+// so that priority is lowered to 5 for them. The
+// apns-topic header is only set if topic is not empty; with certificate
+// authentication it defaults to the app the certificate is for.
+func NewHTTP2Request(host, topic string, pn PushNotification) (*http.Request, error) {
+	var (
+		token      format.Token
+		identifier int32
+		expiry     *int32
+		priority   int8
+		payload    []byte
+		err        error
+	)
+	switch n := pn.(type) {
+	case *format.SimpleNotification:
+		token = n.Token
+		payload, err = n.MarshalPayload()
+	case *format.EnhancedNotification:
+		token, identifier, expiry = n.Token, n.Identifier, &n.Expiry
+		payload, err = n.MarshalPayload()
+	case *format.Notification:
+		token, identifier, expiry, priority = n.Token, n.Identifier, &n.Expiry, n.Priority
+		payload, err = n.MarshalPayload()
+	default:
+		return nil, fmt.Errorf("Cannot convert %T to an HTTP/2 request.", pn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.IsZero() {
+		return nil, ErrInvalidToken
+	}
+	pushType, err := http2PushType(payload)
+	if err != nil {
+		return nil, err
+	}
+	if pushType == "background" && priority == 10 {
+		priority = 5
+	}
+
+	req, err := http.NewRequest("POST", host+"/3/device/"+token.Hex(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if topic != "" {
+		req.Header.Set("apns-topic", topic)
+	}
+	if pushType != "" {
+		req.Header.Set("apns-push-type", pushType)
+	}
+	if identifier != 0 {
+		req.Header.Set("apns-id", fmt.Sprintf("00000000-0000-0000-0000-0000%08x", uint32(identifier)))
+	}
+	if expiry != nil {
+		req.Header.Set("apns-expiration", strconv.FormatUint(uint64(uint32(*expiry)), 10))
+	}
+	if priority != 0 {
+		req.Header.Set("apns-priority", strconv.Itoa(int(priority)))
+	}
+	return req, nil
+}
+
+// http2PushType works out the apns-push-type of a payload. A payload
+// without an aps dictionary, such as a Wallet pass update, has none.
+func http2PushType(payload []byte) (string, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &keys); err != nil {
+		return "", err
+	}
+	if _, ok := keys["aps"]; !ok {
+		return "", nil
+	}
+	var p format.Payload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", err
+	}
+	a := p.APS
+	switch {
+	case a.Event != "":
+		if err := a.ValidateLiveActivity(); err != nil {
+			return "", err
+		}
+		return format.LiveActivityPushType, nil
+	case a.ContentAvailable == 1 && a.Alert == nil && a.Badge == nil && a.Sound == "":
+		return "background", nil
+	}
+	return "alert", nil
+}
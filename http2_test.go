@@ -0,0 +1,89 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+func TestNewHTTP2Request(t *testing.T) {
+	token := testNotification(1).Token
+	tests := []struct {
+		name   string
+		pn     apns.PushNotification
+		header http.Header
+	}{
+		{"alert", &format.Notification{Token: token, Identifier: 42, Expiry: 1000, Priority: 10, RawPayload: []byte(`{"aps":{"alert":"hi"}}`)}, http.Header{
+			"Apns-Push-Type":  {"alert"},
+			"Apns-Id":         {"00000000-0000-0000-0000-00000000002a"},
+			"Apns-Expiration": {"1000"},
+			"Apns-Priority":   {"10"},
+		}},
+		{"category only", &format.Notification{Token: token, RawPayload: []byte(`{"aps":{"category":"x"}}`)}, http.Header{
+			"Apns-Push-Type":  {"alert"},
+			"Apns-Expiration": {"0"},
+		}},
+		{"mutable content", &format.EnhancedNotification{Token: token, RawPayload: []byte(`{"aps":{"mutable-content":1}}`)}, http.Header{
+			"Apns-Push-Type":  {"alert"},
+			"Apns-Expiration": {"0"},
+		}},
+		{"background", &format.Notification{Token: token, Priority: 10, RawPayload: []byte(`{"aps":{"content-available":1}}`)}, http.Header{
+			"Apns-Push-Type":  {"background"},
+			"Apns-Expiration": {"0"},
+			"Apns-Priority":   {"5"},
+		}},
+		{"live activity", &format.Notification{Token: token, RawPayload: []byte(`{"aps":{"event":"end","timestamp":1}}`)}, http.Header{
+			"Apns-Push-Type":  {"liveactivity"},
+			"Apns-Expiration": {"0"},
+		}},
+		{"no aps", &format.SimpleNotification{Token: token, RawPayload: []byte(`{}`)}, http.Header{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := apns.NewHTTP2Request(apns.HTTP2Development, "", test.pn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := apns.HTTP2Development + "/3/device/" + token.Hex(); req.URL.String() != want {
+				t.Errorf("URL %s, want %s", req.URL, want)
+			}
+			for key := range req.Header {
+				if test.header.Get(key) == "" {
+					t.Errorf("unexpected header %s: %s", key, req.Header.Get(key))
+				}
+			}
+			for key := range test.header {
+				if got, want := req.Header.Get(key), test.header.Get(key); got != want {
+					t.Errorf("header %s is %q, want %q", key, got, want)
+				}
+			}
+			body, _ := io.ReadAll(req.Body)
+			if want, _ := test.pn.(interface{ MarshalPayload() ([]byte, error) }).MarshalPayload(); string(body) != string(want) {
+				t.Errorf("body %s, want %s", body, want)
+			}
+		})
+	}
+}
+
+func TestNewHTTP2RequestInvalid(t *testing.T) {
+	token := testNotification(1).Token
+	tests := []struct {
+		name string
+		pn   apns.PushNotification
+	}{
+		{"no token", &format.Notification{RawPayload: []byte(`{"aps":{"alert":"hi"}}`)}},
+		{"bad live activity", &format.Notification{Token: token, RawPayload: []byte(`{"aps":{"event":"update","timestamp":1}}`)}},
+	}
+	for _, test := range tests {
+		if _, err := apns.NewHTTP2Request(apns.HTTP2Production, "", test.pn); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
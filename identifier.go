@@ -0,0 +1,50 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"sync/atomic"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// IdentifierAllocator hands out notification identifiers. Identifiers start
+// at 1 and increase by one per call; zero is never returned so it can mean
+// "unassigned". After math.MaxInt32 the sequence wraps around to negative
+// values, which APNs treats as just as opaque. It is safe for concurrent
+// use.
+type IdentifierAllocator struct {
+	last int32
+}
+
+// NewIdentifierAllocator returns an allocator whose first identifier is 1.
+func NewIdentifierAllocator() *IdentifierAllocator {
+	return &IdentifierAllocator{}
+}
+
+// Next returns the next identifier.
+func (a *IdentifierAllocator) Next() int32 {
+	for {
+		if id := atomic.AddInt32(&a.last, 1); id != 0 {
+			return id
+		}
+	}
+}
+
+// Assign gives a notification the next identifier if its format has one
+// and it is still zero. The notification is updated in place and returned.
+func (a *IdentifierAllocator) Assign(pn PushNotification) PushNotification {
+	switch n := pn.(type) {
+	case *format.EnhancedNotification:
+		if n.Identifier == 0 {
+			n.Identifier = a.Next()
+		}
+	case *format.Notification:
+		if n.Identifier == 0 {
+			n.Identifier = a.Next()
+		}
+	}
+	return pn
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrUnknownApp is returned by Manager.Send for an app key that has not
+// been added.
+var ErrUnknownApp = errors.New("No connection for app.")
+
+// Sender sends notifications. It is implemented by Client and
+// ShardedSender.
+type Sender interface {
+	Send(pn PushNotification) error
+	Close() error
+}
+
+// Manager routes notifications for many apps from one process. Each app
+// (usually identified by its bundle ID, which is also its certificate's
+// topic) has its own certificate and so its own connection or pool of
+// connections.
+type Manager struct {
+	// Dialer makes the connections of AddCertificate. NewManager sets it
+	// to a Dialer of the manager's own. For an app which needs different
+	// options, dial a Client with a Dialer of its own and Add it.
+	Dialer *Dialer
+
+	mu   sync.RWMutex
+	apps map[string]Sender
+}
+
+// NewManager returns an empty manager.
+func NewManager() *Manager {
+	return &Manager{
+		Dialer: NewDialer(),
+		apps:   make(map[string]Sender),
+	}
+}
+
+// Add registers the sender to use for an app, closing any sender it
+// replaces.
+func (m *Manager) Add(app string, s Sender) {
+	m.mu.Lock()
+	old := m.apps[app]
+	m.apps[app] = s
+	m.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+// AddCertificate dials a reconnecting Client for an app using its
+// certificate and registers it.
+func (m *Manager) AddCertificate(app string, cert *tls.Certificate, env Environment) error {
+	d := m.Dialer
+	c, err := DialClient(func() (net.Conn, error) {
+		return d.DialAPN(cert, env, false)
+	})
+	if err != nil {
+		return err
+	}
+	m.Add(app, c)
+	return nil
+}
+
+// Remove closes and forgets the sender for an app.
+func (m *Manager) Remove(app string) error {
+	m.mu.Lock()
+	s := m.apps[app]
+	delete(m.apps, app)
+	m.mu.Unlock()
+	if s == nil {
+		return ErrUnknownApp
+	}
+	return s.Close()
+}
+
+// Sender returns the sender registered for an app, or nil.
+func (m *Manager) Sender(app string) Sender {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.apps[app]
+}
+
+// Send sends a notification using the app's sender.
+func (m *Manager) Send(app string, pn PushNotification) error {
+	s := m.Sender(app)
+	if s == nil {
+		return ErrUnknownApp
+	}
+	return s.Send(pn)
+}
+
+// Close closes every app's sender.
+func (m *Manager) Close() (err error) {
+	m.mu.Lock()
+	apps := m.apps
+	m.apps = make(map[string]Sender)
+	m.mu.Unlock()
+	for _, s := range apps {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
@@ -0,0 +1,23 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import "github.com/cfilipov/apns/format"
+
+// NewPassUpdate returns a notification telling Wallet (Passbook) that a
+// pass on the device with the given push token has changed, so the device
+// fetches the latest version from the web service. The payload is an
+// empty dictionary, with no aps key.
+//
+// Pass update notifications must be sent to the production gateway over a
+// connection authenticated with the pass type ID certificate used to sign
+// the pass, rather than an app's push certificate.
+func NewPassUpdate(token format.Token) *format.Notification {
+	return &format.Notification{
+		Token:    token,
+		Priority: 10,
+		Payload:  format.JSON{},
+	}
+}
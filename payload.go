@@ -0,0 +1,143 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"errors"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// ErrReservedKey is returned when a custom payload key would clobber the
+// aps dictionary.
+var ErrReservedKey = format.ErrReservedKey
+
+// ErrEmptyAPS is returned when building a payload with nothing in its aps
+// dictionary, which would neither alert the user nor wake the app.
+var ErrEmptyAPS = errors.New("Payload has an empty aps dictionary.")
+
+// PayloadBuilder constructs a notification payload one property at a time:
+//
+// 		p, err := apns.NewPayload().Alert("Hello World").Badge(3).Sound("default").JSON()
+//
+// The first error encountered is remembered and reported when the payload
+// is built.
+type PayloadBuilder struct {
+	p   format.Payload
+	err error
+}
+
+// NewPayload starts an empty payload.
+func NewPayload() *PayloadBuilder {
+	return &PayloadBuilder{}
+}
+
+func (b *PayloadBuilder) alert() *format.Alert {
+	if b.p.APS.Alert == nil {
+		b.p.APS.Alert = &format.Alert{}
+	}
+	return b.p.APS.Alert
+}
+
+// Alert sets the text of the alert message.
+func (b *PayloadBuilder) Alert(body string) *PayloadBuilder {
+	b.alert().Body = body
+	return b
+}
+
+// Title sets the title of the alert.
+func (b *PayloadBuilder) Title(title string) *PayloadBuilder {
+	b.alert().Title = title
+	return b
+}
+
+// LocalizedAlert sets the alert text to the localized string locKey, with
+// args substituted for its format specifiers.
+func (b *PayloadBuilder) LocalizedAlert(locKey string, args ...string) *PayloadBuilder {
+	a := b.alert()
+	a.LocKey, a.LocArgs = locKey, args
+	return b
+}
+
+// LocalizedTitle sets the alert title to the localized string titleLocKey,
+// with args substituted for its format specifiers.
+func (b *PayloadBuilder) LocalizedTitle(titleLocKey string, args ...string) *PayloadBuilder {
+	b.alert().SetLocalizedTitle(titleLocKey, args...)
+	return b
+}
+
+// Badge sets the number displayed on the app icon. Zero removes the badge.
+func (b *PayloadBuilder) Badge(n int) *PayloadBuilder {
+	b.p.APS.Badge = &n
+	return b
+}
+
+// Sound sets the sound to play.
+func (b *PayloadBuilder) Sound(sound string) *PayloadBuilder {
+	b.p.APS.Sound = sound
+	return b
+}
+
+// ContentAvailable marks the notification as signalling new content for a
+// background download.
+func (b *PayloadBuilder) ContentAvailable() *PayloadBuilder {
+	b.p.APS.ContentAvailable = 1
+	return b
+}
+
+// Category sets the notification category, which selects the actions
+// shown with it.
+func (b *PayloadBuilder) Category(category string) *PayloadBuilder {
+	b.p.APS.Category = category
+	return b
+}
+
+// ThreadID sets the identifier used to group related notifications.
+func (b *PayloadBuilder) ThreadID(id string) *PayloadBuilder {
+	b.p.APS.ThreadID = id
+	return b
+}
+
+// Custom adds a provider-specific key to the payload, outside the aps
+// dictionary.
+func (b *PayloadBuilder) Custom(key string, value interface{}) *PayloadBuilder {
+	if err := b.p.Set(key, value); err != nil && b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+// Payload returns the typed payload.
+func (b *PayloadBuilder) Payload() (format.Payload, error) {
+	return b.p, b.err
+}
+
+// JSON returns the payload in the form held by notifications, once it is
+// checked to fit a command 2 notification. See JSONFor.
+func (b *PayloadBuilder) JSON() (format.JSON, error) {
+	return b.JSONFor(format.NotificationCMD)
+}
+
+// JSONFor returns the payload in the form held by notifications with the
+// given command. It fails with ErrEmptyAPS if nothing was set in the aps
+// dictionary, and with a format.ValidationError if the payload breaks
+// Apple's rules, such as being over the size limit for the command.
+func (b *PayloadBuilder) JSONFor(command format.Command) (format.JSON, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	j, err := b.p.JSON()
+	if err != nil {
+		return nil, err
+	}
+	// An empty aps dictionary is left out when marshaling.
+	if _, ok := j["aps"]; !ok {
+		return nil, ErrEmptyAPS
+	}
+	if err := format.ValidatePayload(command, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+func TestPayloadBuilderValidates(t *testing.T) {
+	j, err := apns.NewPayload().Alert("Hello World").Badge(3).Custom("msg", "Boo!").JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := j["aps"]; !ok || j["msg"] != "Boo!" {
+		t.Errorf("got %v, want aps and the msg key", j)
+	}
+
+	if _, err := apns.NewPayload().Custom("msg", "Boo!").JSON(); err != apns.ErrEmptyAPS {
+		t.Errorf("payload without aps: got %v, want ErrEmptyAPS", err)
+	}
+	if _, err := apns.NewPayload().Custom("aps", 1).Alert("hi").JSON(); err != apns.ErrReservedKey {
+		t.Errorf("custom aps key: got %v, want ErrReservedKey", err)
+	}
+
+	// 300 bytes fits command 2 but not the older formats.
+	b := apns.NewPayload().Alert(strings.Repeat("x", 300))
+	if _, err := b.JSON(); err != nil {
+		t.Errorf("command 2: %v", err)
+	}
+	var sizeErr *format.PayloadSizeError
+	if _, err := b.JSONFor(format.EnhancedNotificationCMD); !errors.As(err, &sizeErr) {
+		t.Errorf("command 1: got %v, want a PayloadSizeError", err)
+	}
+}
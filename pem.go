@@ -22,6 +22,21 @@ func LoadPemFile(pemFile string) (cert tls.Certificate, err error) {
 	return LoadPem(pemBlock)
 }
 
+// LoadCAFile reads one or more PEM encoded CA certificates into a pool,
+// for example Apple's root and intermediate certificates to use as the
+// RootCAs of a Dialer.
+func LoadCAFile(caFile string) (*x509.CertPool, error) {
+	pemBlock, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBlock) {
+		return nil, errors.New("crypto/tls: failed to parse CA certificate PEM data")
+	}
+	return pool, nil
+}
+
 // LoadPem is similar to tls.X509KeyPair found in tls.go except that this 
 // function reads all blocks from the same file.
 func LoadPem(pemBlock []byte) (cert tls.Certificate, err error) {
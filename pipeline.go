@@ -0,0 +1,179 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// pipelineBuffer is the capacity of the Notifications and Errors channels.
+const pipelineBuffer = 256
+
+// Notifications returns a channel on which notifications can be queued for
+// sending. Queued notifications are written in batches: everything already
+// waiting in the channel is buffered and flushed with as few writes as
+// possible, which is how Apple recommends high volumes be sent.
+//
+// Close the channel to stop the pipeline. A queued notification which
+// cannot be sent, because it is refused before writing or the connection
+// has failed, is reported on the Errors channel. Once the connection ends
+// for good, queued notifications are dropped; use Err to find out why.
+func (c *Client) Notifications() chan<- PushNotification {
+	c.startPipeline()
+	return c.notifs
+}
+
+// Errors returns a channel that receives every error response read from
+// the connection, and one for each queued notification the pipeline could
+// not send, from the first call to Notifications or Errors on. The channel
+// is closed when the connection ends, once everything before that has
+// been received.
+//
+// Errors are never dropped: those the caller is not ready for are queued
+// in memory, without limit, until received. Callers using the pipeline
+// should keep receiving from Errors.
+func (c *Client) Errors() <-chan *format.NotificationError {
+	c.startPipeline()
+	return c.errs
+}
+
+// startPipeline starts the pipeline and the forwarding of errors to the
+// Errors channel, the first time it is called.
+func (c *Client) startPipeline() {
+	c.pipeOnce.Do(func() {
+		c.mu.Lock()
+		c.piped = true
+		ended := c.errsEnded
+		c.mu.Unlock()
+		if !ended {
+			go c.forwardErrors()
+		}
+		go c.pipeline()
+	})
+}
+
+// pipeline drains the Notifications channel, writing each batch of queued
+// notifications through a buffer and flushing once the channel is empty.
+func (c *Client) pipeline() {
+	w := bufio.NewWriter(nil)
+	var written []PushNotification
+	for pn := range c.notifs {
+		// Count pn as in flight while waiting on the limiter, so Drain
+		// doesn't take the empty channel to mean nothing is left.
+		c.mu.Lock()
+		c.inflight++
+		c.mu.Unlock()
+		c.wait()
+		c.mu.Lock()
+		c.inflight--
+		// The connection may have been replaced since the last batch.
+		w.Reset(c.conn)
+		written = written[:0]
+		err := c.err
+		if err == nil {
+			written, err = c.pipe(w, written, pn)
+		} else {
+			c.report(pn, err)
+		}
+	batch:
+		for err == nil {
+			// Rather than block while holding the lock, end the batch
+			// when the rate limiter runs dry.
+			if c.Limiter != nil && (len(c.notifs) == 0 || !c.Limiter.Allow()) {
+				break
+			}
+			select {
+			case next, ok := <-c.notifs:
+				if !ok {
+					break batch
+				}
+				written, err = c.pipe(w, written, next)
+			default:
+				break batch
+			}
+		}
+		if err == nil && len(written) > 0 {
+			c.lastWrite = c.Clock.Now()
+			err = w.Flush()
+		}
+		if err != nil && c.err == nil {
+			// None of the batch is known to have reached APNs.
+			for _, pn := range written {
+				c.report(pn, err)
+			}
+			c.err = err
+		}
+		c.mu.Unlock()
+	}
+}
+
+// pipe writes one queued notification to the batch buffer and adds it to
+// the notifications written. One refused by prepare is reported instead;
+// only write errors are returned.
+func (c *Client) pipe(w io.Writer, written []PushNotification, pn PushNotification) ([]PushNotification, error) {
+	prepared, err := c.prepare(pn)
+	if err != nil {
+		c.report(pn, err)
+		return written, nil
+	}
+	return append(written, prepared), c.write(w, prepared)
+}
+
+// report queues an error response for a queued notification which was not
+// sent, to be received from the Errors channel. The caller holds c.mu.
+func (c *Client) report(pn PushNotification, err error) {
+	id, _ := notificationID(pn)
+	c.queueError(&format.NotificationError{
+		Command:    format.NotificationErrorCMD,
+		Status:     errorStatus(err),
+		Identifier: id,
+	})
+}
+
+// queueError queues an error to be received from the Errors channel,
+// unless the pipeline has not started or the connection has ended for
+// good. The caller holds c.mu.
+func (c *Client) queueError(nerr *format.NotificationError) {
+	if !c.piped || c.errsEnded {
+		return
+	}
+	c.errq = append(c.errq, nerr)
+	c.wakeErrors()
+}
+
+// wakeErrors tells forwardErrors to look at the queue again. The caller
+// holds c.mu.
+func (c *Client) wakeErrors() {
+	select {
+	case c.errsWake <- struct{}{}:
+	default:
+	}
+}
+
+// forwardErrors sends queued errors on the Errors channel, blocking for
+// as long as the receiver needs, and closes the channel once the
+// connection has ended and the queue is empty.
+func (c *Client) forwardErrors() {
+	for {
+		c.mu.Lock()
+		queue, ended := c.errq, c.errsEnded
+		c.errq = nil
+		c.mu.Unlock()
+		for _, nerr := range queue {
+			c.errs <- nerr
+		}
+		if len(queue) > 0 {
+			continue
+		}
+		if ended {
+			close(c.errs)
+			return
+		}
+		<-c.errsWake
+	}
+}
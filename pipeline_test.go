@@ -0,0 +1,131 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+	"github.com/cfilipov/apns/format"
+)
+
+// nextError waits for an error response on the Errors channel.
+func nextError(t *testing.T, c *apns.Client) *format.NotificationError {
+	t.Helper()
+	select {
+	case nerr, ok := <-c.Errors():
+		if !ok {
+			t.Fatal("Errors closed")
+		}
+		return nerr
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error response")
+		return nil
+	}
+}
+
+func TestPipelineReportsRefused(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	c := dialTest(t, srv)
+	c.Use(apns.Hooks{BeforeSend: func(pn apns.PushNotification) (apns.PushNotification, error) {
+		if pn.(*format.Notification).Identifier == 2 {
+			return nil, &apns.StatusError{Status: format.InvalidPayloadSizeStatus, Identifier: 2}
+		}
+		return pn, nil
+	}})
+
+	zero := testNotification(1)
+	zero.Token = format.Token{}
+	c.Notifications() <- zero
+	c.Notifications() <- testNotification(2)
+	c.Notifications() <- testNotification(3)
+
+	want := []format.NotificationError{
+		{Command: format.NotificationErrorCMD, Status: format.MissingTokenStatus, Identifier: 1},
+		{Command: format.NotificationErrorCMD, Status: format.InvalidPayloadSizeStatus, Identifier: 2},
+	}
+	for _, w := range want {
+		if got := nextError(t, c); *got != w {
+			t.Errorf("got %v, want %v", got, w)
+		}
+	}
+	if got := srv.WaitReceived(1, 5*time.Second); len(got) != 1 || got[0].(*format.Notification).Identifier != 3 {
+		t.Errorf("server received %v, want only notification 3", got)
+	}
+}
+
+func TestPipelineReportsSkipped(t *testing.T) {
+	srv := apnstest.NewServer()
+	// The client stays reconnecting, and so keeps Errors open, until the
+	// test ends.
+	reconnect := make(chan struct{})
+	defer close(reconnect)
+	dialed := false
+	c, err := apns.DialClient(func() (net.Conn, error) {
+		if dialed {
+			<-reconnect
+			return nil, errors.New("test over")
+		}
+		dialed = true
+		return net.Dial("tcp", srv.Addr())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	srv.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not lost")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for id := int32(1); id <= 3; id++ {
+		c.Notifications() <- testNotification(id)
+	}
+	for id := int32(1); id <= 3; id++ {
+		nerr := nextError(t, c)
+		if nerr.Identifier != id || nerr.Status != format.UnknownStatus {
+			t.Errorf("got %v, want notification %d with the unknown status", nerr, id)
+		}
+	}
+}
+
+func TestPipelineErrorsNotDropped(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	c := dialTest(t, srv)
+
+	// More refusals than the Errors channel holds, before any is received.
+	const n = 3 * 256
+	for id := int32(1); id <= n; id++ {
+		pn := testNotification(id)
+		pn.Token = format.Token{}
+		c.Notifications() <- pn
+	}
+	for id := int32(1); id <= n; id++ {
+		if nerr := nextError(t, c); nerr.Identifier != id {
+			t.Fatalf("got an error for notification %d, want %d", nerr.Identifier, id)
+		}
+	}
+
+	c.Close()
+	select {
+	case nerr, ok := <-c.Errors():
+		if ok {
+			t.Errorf("got %v after Close, want Errors closed", nerr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Errors not closed after Close")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import "github.com/cfilipov/apns/format"
+
+// Action is what a Client does when APNs answers a notification with an
+// error response. APNs closes the connection after the response and
+// discards every notification sent after the one it identifies, so the
+// action covers those too.
+type Action int
+
+const (
+	// DropAction resolves the rejected notification with a *StatusError
+	// and the discarded ones with ErrDiscarded, leaving it to the caller
+	// to send them again.
+	DropAction Action = iota
+
+	// InvalidateTokenAction drops the notifications as DropAction does,
+	// and also reports the device token of the rejected one to the
+	// client's InvalidTokens handler.
+	InvalidateTokenAction
+
+	// RetryAction sets the rejected notification and the discarded ones
+	// aside, and sends them again once the client has reconnected. A
+	// notification is retried at most RetryLimit times.
+	RetryAction
+
+	// ReconnectAction resolves the rejected notification with a
+	// *StatusError, and sends the discarded ones again once the client
+	// has reconnected.
+	ReconnectAction
+)
+
+var actionNames = []string{"drop", "invalidate-token", "retry", "reconnect"}
+
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return "unknown"
+	}
+	return actionNames[a]
+}
+
+// RetryPolicy maps the status of an error response to the action a Client
+// takes. Statuses missing from it are handled with DropAction.
+//
+// A Shutdown response is special: its identifier is that of the last
+// notification APNs accepted rather than of a rejected one, so under any
+// action the notifications up to it are delivered and only the ones after
+// it are affected.
+type RetryPolicy map[format.Status]Action
+
+// DefaultRetryPolicy follows the meaning Apple documents for each status.
+// Failures of APNs itself are retried, and the notifications discarded
+// because of a shutdown are sent again on a new connection. Tokens APNs
+// will never accept are invalidated. Anything else is a malformed
+// notification, which would only be rejected again, so it is dropped.
+var DefaultRetryPolicy = RetryPolicy{
+	format.ProcessingErrorsStatus: RetryAction,
+	format.UnknownStatus:          RetryAction,
+	format.ShutdownStatus:         ReconnectAction,
+	format.InvalidTokenStatus:     InvalidateTokenAction,
+	format.InvalidTokenSizeStatus: InvalidateTokenAction,
+}
+
+// DefaultRetryLimit is how many times a Client resends a notification
+// under RetryAction before resolving it with its *StatusError.
+const DefaultRetryLimit = 3
+
+// Action returns the action for a status. A nil policy is the
+// DefaultRetryPolicy.
+func (p RetryPolicy) Action(s format.Status) Action {
+	if p == nil {
+		p = DefaultRetryPolicy
+	}
+	return p[s]
+}
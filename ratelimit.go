@@ -0,0 +1,90 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket which caps how many notifications per
+// second a Client writes. The bucket holds up to burst tokens and refills
+// at rate tokens per second; each notification takes one token.
+type RateLimiter struct {
+	// Clock is the limiter's source of time. NewRateLimiter sets it to
+	// SystemClock.
+	Clock Clock
+
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+
+	// Time of the last refill, by Clock. Zero until the first, so that a
+	// Clock set after NewRateLimiter is the only one ever read.
+	last time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate notifications per second
+// on average, with bursts of up to burst notifications. The bucket starts
+// full. A rate of 0 or less allows everything.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		Clock:  SystemClock,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// refill adds the tokens accumulated since the last call. The bucket is
+// still full at the first call. The caller must hold l.mu.
+func (l *RateLimiter) refill() {
+	now := l.Clock.Now()
+	if l.last.IsZero() {
+		l.last = now
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// Allow takes a token if one is available and reports whether it did.
+func (l *RateLimiter) Allow() bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Wait blocks until a token is available and takes it.
+func (l *RateLimiter) Wait() {
+	if l.rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.refill()
+	// The token may be borrowed from the future, in which case sleep
+	// until it exists. Waiters after us borrow further ahead, so they
+	// stay in line without anyone sleeping under the lock.
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay > 0 {
+		l.Clock.Sleep(delay)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+)
+
+// stoppedClock is a clock which only advances when a test sets now. Sleep
+// records the delay and blocks until wake is closed.
+type stoppedClock struct {
+	now    time.Time
+	wake   chan struct{}
+	mu     sync.Mutex
+	sleeps []time.Duration
+}
+
+func (c *stoppedClock) Now() time.Time { return c.now }
+
+func (c *stoppedClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	c.sleeps = append(c.sleeps, d)
+	c.mu.Unlock()
+	<-c.wake
+}
+
+func (c *stoppedClock) AfterFunc(d time.Duration, f func()) apns.Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (c *stoppedClock) slept() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.sleeps...)
+}
+
+func TestRateLimiterWaitDoesNotBlockOthers(t *testing.T) {
+	clock := &stoppedClock{now: time.Now(), wake: make(chan struct{})}
+	defer close(clock.wake)
+	l := apns.NewRateLimiter(10, 1)
+	l.Clock = clock
+
+	l.Wait() // takes the burst
+	go l.Wait()
+	go l.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(clock.slept()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("waiters slept %v, want both sleeping at once", clock.slept())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan bool)
+	go func() { done <- l.Allow() }()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("Allow took a token borrowed by the waiters")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Allow blocked while a Wait slept")
+	}
+
+	// Each waiter sleeps until its own token exists.
+	got := clock.slept()
+	if got[0]+got[1] != 300*time.Millisecond {
+		t.Errorf("waiters slept %v, want 100ms and 200ms", got)
+	}
+}
+
+func TestRateLimiterZeroRate(t *testing.T) {
+	clock := &stoppedClock{now: time.Now(), wake: make(chan struct{})}
+	close(clock.wake)
+	l := apns.NewRateLimiter(0, 1)
+	l.Clock = clock
+	for i := 0; i < 10; i++ {
+		if !l.Allow() {
+			t.Fatalf("Allow %d refused with a rate of 0", i)
+		}
+		l.Wait()
+	}
+	if got := clock.slept(); len(got) != 0 {
+		t.Errorf("Wait slept %v with a rate of 0", got)
+	}
+}
+
+func TestRateLimiterFakeClockFromCreation(t *testing.T) {
+	// Far from the system clock in both directions, so reading it for
+	// the first refill would empty or overfill the bucket.
+	for _, start := range []time.Time{time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Now().Add(24 * time.Hour)} {
+		clock := &stoppedClock{now: start, wake: make(chan struct{})}
+		close(clock.wake)
+		l := apns.NewRateLimiter(1, 2)
+		l.Clock = clock
+
+		for i := 0; i < 2; i++ {
+			if !l.Allow() {
+				t.Fatalf("%v: Allow %d refused with a full bucket", start, i)
+			}
+		}
+		if l.Allow() {
+			t.Errorf("%v: Allow took a token beyond the burst", start)
+		}
+		clock.now = clock.now.Add(time.Second)
+		if !l.Allow() {
+			t.Errorf("%v: Allow refused a second after the bucket emptied", start)
+		}
+		if l.Allow() {
+			t.Errorf("%v: Allow took a second token a second after the bucket emptied", start)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// TokenRecord is a device token known to the provider, with the time the
+// app last registered it.
+type TokenRecord struct {
+	// The device token.
+	Token format.Token
+
+	// When the app last registered the token with the provider. The zero
+	// time means unknown.
+	RegisteredAt time.Time
+}
+
+// ReconcileReport lists what to do about the tokens reported by the
+// feedback service.
+type ReconcileReport struct {
+	// Tokens the app no longer accepts notifications on, which should be
+	// deactivated.
+	Deactivate []format.Token
+
+	// Tokens reported by feedback but registered again since, which
+	// should be kept.
+	Reregistered []format.Token
+
+	// Tokens reported by feedback that were not in the list sent to.
+	Unknown []format.Token
+}
+
+// Reconcile matches the tokens notifications were sent to against feedback
+// tuples, read from the channel until it is closed (see
+// FeedbackConnection.Receive), and reports which tokens to deactivate. A
+// token reported more than once is judged by its newest tuple.
+func Reconcile(sent []TokenRecord, feedback <-chan format.Feedback) *ReconcileReport {
+	registered := make(map[format.Token]time.Time, len(sent))
+	for _, rec := range sent {
+		registered[rec.Token] = rec.RegisteredAt
+	}
+
+	latest := make(map[format.Token]time.Time)
+	var order []format.Token
+	for fb := range feedback {
+		token := fb.Token
+		t, seen := latest[token]
+		if !seen {
+			order = append(order, token)
+		}
+		if !seen || fb.Time().After(t) {
+			latest[token] = fb.Time()
+		}
+	}
+
+	report := &ReconcileReport{}
+	for _, token := range order {
+		reg, ok := registered[token]
+		switch {
+		case !ok:
+			report.Unknown = append(report.Unknown, token)
+		case reg.After(latest[token]):
+			report.Reregistered = append(report.Reregistered, token)
+		default:
+			report.Deactivate = append(report.Deactivate, token)
+		}
+	}
+	return report
+}
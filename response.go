@@ -0,0 +1,48 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// ReadErrorResponse waits up to window for APNs to send an error response
+// on conn, typically called right after the last notification is written.
+// There are three possible outcomes:
+//
+// 		- An error response arrived: it is returned.
+// 		- The window elapsed in silence: both return values are nil, and the
+// 		  notifications can be assumed delivered.
+// 		- The connection failed or was closed, or the window elapsed partway
+// 		  through a packet: the error is returned.
+//
+// Packets other than error responses are ignored. The read deadline of
+// conn is cleared on return, replacing any the caller had set.
+func ReadErrorResponse(conn net.Conn, window time.Duration) (*format.NotificationError, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(window)); err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		p, err := ReadCommand(conn)
+		// A timeout before the first byte of a packet is silence, but one
+		// partway through is a *ParseError: a response was cut short.
+		var nerr net.Error
+		var perr *ParseError
+		if errors.As(err, &nerr) && nerr.Timeout() && !errors.As(err, &perr) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp, ok := p.(*format.NotificationError); ok {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/format"
+)
+
+func TestReadErrorResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		sent   []byte
+		want   *format.NotificationError
+		failed bool
+	}{
+		{"silence", nil, nil, false},
+		{"error response", []byte{8, 8, 0, 0, 0, 42}, &format.NotificationError{Command: format.NotificationErrorCMD, Status: 8, Identifier: 42}, false},
+		{"cut short", []byte{8, 8, 0}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go server.Write(test.sent)
+
+			resp, err := apns.ReadErrorResponse(client, 50*time.Millisecond)
+			var perr *apns.ParseError
+			if test.failed != errors.As(err, &perr) {
+				t.Fatalf("error %v, want a *ParseError: %v", err, test.failed)
+			}
+			if !test.failed && err != nil {
+				t.Fatal(err)
+			}
+			if (resp == nil) != (test.want == nil) || resp != nil && *resp != *test.want {
+				t.Errorf("response %v, want %v", resp, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// ErrNoShards is returned by NewShardedSender when no clients are given.
+var ErrNoShards = errors.New("Sharded sender needs at least one client.")
+
+// ShardedSender spreads notifications across several clients (and so
+// several connections) by hashing the device token. Every notification for
+// a given token goes through the same client's pipeline, so per-token
+// ordering is preserved while throughput scales with the number of
+// connections.
+type ShardedSender struct {
+	clients []*Client
+
+	// mu is held for reading while queuing, so that Drain cannot close a
+	// channel a Send is about to queue on.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewShardedSender creates a sender over the given clients. The clients
+// should not be used for sending directly while the sender is in use.
+func NewShardedSender(clients ...*Client) (*ShardedSender, error) {
+	if len(clients) == 0 {
+		return nil, ErrNoShards
+	}
+	return &ShardedSender{clients: clients}, nil
+}
+
+// Shard returns the client responsible for a device token.
+func (s *ShardedSender) Shard(token format.Token) *Client {
+	h := fnv.New32a()
+	h.Write(token[:])
+	return s.clients[h.Sum32()%uint32(len(s.clients))]
+}
+
+// Send queues a notification on the pipeline of the client that owns its
+// device token. It fails only if that client's connection has already
+// broken, or with ErrClientClosed once the sender is closed.
+func (s *ShardedSender) Send(pn PushNotification) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrClientClosed
+	}
+	c := s.Shard(notificationToken(pn))
+	if err := c.Err(); err != nil {
+		return err
+	}
+	c.Notifications() <- pn
+	return nil
+}
+
+// Drain stops accepting notifications and drains every shard, so that
+// everything already queued is written and its error window waited out
+// before the connections close. If ctx is done first the remaining
+// connections are closed anyway and the context's error is returned.
+func (s *ShardedSender) Drain(ctx context.Context) (err error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for _, c := range s.clients {
+		close(c.Notifications())
+	}
+	s.mu.Unlock()
+	for _, c := range s.clients {
+		if cerr := c.Drain(ctx); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// Close drains every shard, as Drain does without a deadline.
+func (s *ShardedSender) Close() error {
+	return s.Drain(context.Background())
+}
+
+// notificationToken returns the device token of a notification.
+func notificationToken(pn PushNotification) format.Token {
+	switch n := pn.(type) {
+	case *format.SimpleNotification:
+		return n.Token
+	case *format.EnhancedNotification:
+		return n.Token
+	case *format.Notification:
+		return n.Token
+	}
+	return format.Token{}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cfilipov/apns"
+	"github.com/cfilipov/apns/apnstest"
+)
+
+// newShardedTest returns a sender over shards clients of a mock gateway,
+// with an error window short enough to drain quickly.
+func newShardedTest(t *testing.T, srv *apnstest.Server, shards int) *apns.ShardedSender {
+	t.Helper()
+	clients := make([]*apns.Client, shards)
+	for i := range clients {
+		clients[i] = dialTest(t, srv)
+		clients[i].ErrorWindow = 50 * time.Millisecond
+	}
+	s, err := apns.NewShardedSender(clients...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestShardedCloseWritesQueued(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	s := newShardedTest(t, srv, 3)
+
+	const n = 500
+	for id := int32(1); id <= n; id++ {
+		if err := s.Send(testNotification(id)); err != nil {
+			t.Fatalf("Send %d: %v", id, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := len(srv.WaitReceived(n, time.Second)); got != n {
+		t.Errorf("server received %d notifications, want all %d queued before Close", got, n)
+	}
+	if err := s.Send(testNotification(n + 1)); err != apns.ErrClientClosed {
+		t.Errorf("Send after Close: got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestShardedSendRacingClose(t *testing.T) {
+	srv := apnstest.NewServer()
+	defer srv.Close()
+	s := newShardedTest(t, srv, 2)
+
+	var wg sync.WaitGroup
+	for g := int32(0); g < 4; g++ {
+		wg.Add(1)
+		go func(g int32) {
+			defer wg.Done()
+			for id := g * 1000; id < g*1000+200; id++ {
+				if err := s.Send(testNotification(id)); err == apns.ErrClientClosed {
+					return
+				} else if err != nil {
+					t.Errorf("Send %d: %v", id, err)
+					return
+				}
+			}
+		}(g)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cfilipov/apns/format"
+)
+
+// Spool is a durable record of notifications which have been handed to a
+// Client but whose outcome is not yet known. A Client appends each
+// notification before writing it and completes it once the error window has
+// passed (or APNs rejected it for good), so after a crash the notifications
+// still pending can be sent again with Client.Resume. Delivery is therefore
+// at-least-once.
+type Spool interface {
+	// Append records a notification and returns its sequence number.
+	Append(pn PushNotification) (seq uint64, err error)
+
+	// Complete marks a notification as no longer needing delivery.
+	Complete(seq uint64) error
+
+	// Pending returns every appended notification not yet completed, in
+	// the order they were appended.
+	Pending() ([]SpoolEntry, error)
+}
+
+// SpoolEntry is a notification recorded in a Spool.
+type SpoolEntry struct {
+	Seq          uint64
+	Notification PushNotification
+}
+
+// journalRecord is one line of a FileSpool journal. A record either adds a
+// notification or completes an earlier one.
+type journalRecord struct {
+	Seq          uint64          `json:"seq"`
+	Notification json.RawMessage `json:"notification,omitempty"`
+	Done         bool            `json:"done,omitempty"`
+}
+
+// FileSpool is a Spool backed by an append-only journal file with one JSON
+// record per line. Each record is synced to disk before Append or Complete
+// returns. The journal is compacted to the pending notifications when it
+// is opened, and emptied whenever none are pending.
+type FileSpool struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	seq     uint64
+	pending map[uint64]PushNotification
+}
+
+// OpenFileSpool opens (or creates) a journal file and replays it to find
+// the notifications still pending. A corrupt record is an error, leaving
+// the journal untouched, unless it is the torn last line of a crash.
+func OpenFileSpool(path string) (*FileSpool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileSpool{
+		path:    path,
+		f:       f,
+		pending: make(map[uint64]PushNotification),
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	// A torn final line from a crash mid-write is expected, so a line which
+	// does not parse is only an error if another line follows it.
+	var torn error
+	for line := 1; scanner.Scan(); line++ {
+		if torn != nil {
+			f.Close()
+			return nil, torn
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			torn = fmt.Errorf("spool %s line %d: %w", path, line, err)
+			continue
+		}
+		if rec.Seq > s.seq {
+			s.seq = rec.Seq
+		}
+		if rec.Done {
+			delete(s.pending, rec.Seq)
+			continue
+		}
+		pn, err := MakeNotification(rec.Notification)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("spool %s line %d: %w", path, line, err)
+		}
+		s.pending[rec.Seq] = pn
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// compact rewrites the journal with only the pending notifications. The
+// new journal replaces the old by renaming, so a crash leaves one or the
+// other whole.
+func (s *FileSpool) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range s.entries() {
+		err = writeRecord(w, journalRecord{Seq: e.Seq, Notification: json.RawMessage(e.Notification.Text(format.JSONStyle))})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	s.f.Close()
+	s.f = f
+	return nil
+}
+
+// writeRecord writes one line of a journal.
+func writeRecord(w io.Writer, rec journalRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func (s *FileSpool) write(rec journalRecord) error {
+	if err := writeRecord(s.f, rec); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Append implements Spool.
+func (s *FileSpool) Append(pn PushNotification) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := s.seq + 1
+	if err := s.write(journalRecord{Seq: seq, Notification: json.RawMessage(pn.Text(format.JSONStyle))}); err != nil {
+		return 0, err
+	}
+	s.seq = seq
+	s.pending[seq] = pn
+	return seq, nil
+}
+
+// Complete implements Spool.
+func (s *FileSpool) Complete(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[seq]; !ok {
+		return nil
+	}
+	if len(s.pending) == 1 {
+		// Nothing is left pending, so nothing in the journal is needed.
+		if err := s.f.Truncate(0); err != nil {
+			return err
+		}
+		delete(s.pending, seq)
+		return s.f.Sync()
+	}
+	if err := s.write(journalRecord{Seq: seq, Done: true}); err != nil {
+		return err
+	}
+	delete(s.pending, seq)
+	return nil
+}
+
+// Pending implements Spool.
+func (s *FileSpool) Pending() ([]SpoolEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries(), nil
+}
+
+// entries returns the pending notifications in the order they were
+// appended. The caller must hold s.mu, or be opening the spool.
+func (s *FileSpool) entries() []SpoolEntry {
+	entries := make([]SpoolEntry, 0, len(s.pending))
+	for seq, pn := range s.pending {
+		entries = append(entries, SpoolEntry{Seq: seq, Notification: pn})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries
+}
+
+// Close closes the journal file.
+func (s *FileSpool) Close() error {
+	return s.f.Close()
+}
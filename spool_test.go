@@ -0,0 +1,126 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cfilipov/apns"
+)
+
+// journalLines returns the number of records in a journal file.
+func journalLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFileSpoolCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := apns.OpenFileSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for id := int32(1); id <= 3; id++ {
+		seq, err := s.Append(testNotification(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if err := s.Complete(seqs[0]); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if n := journalLines(t, path); n != 4 {
+		t.Fatalf("journal has %d records before reopening, want 4", n)
+	}
+
+	// Reopening rewrites the journal with only the pending notifications.
+	if s, err = apns.OpenFileSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := journalLines(t, path); n != 2 {
+		t.Errorf("journal has %d records after reopening, want the 2 pending", n)
+	}
+	pending, err := s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Seq != seqs[1] || pending[1].Seq != seqs[2] {
+		t.Fatalf("pending %v, want sequence numbers %v", pending, seqs[1:])
+	}
+
+	// Completing the last pending notification empties the journal, and
+	// it can be appended to again.
+	for _, e := range pending {
+		if err := s.Complete(e.Seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := journalLines(t, path); n != 0 {
+		t.Errorf("journal has %d records with nothing pending, want 0", n)
+	}
+	if _, err := s.Append(testNotification(4)); err != nil {
+		t.Fatal(err)
+	}
+	if n := journalLines(t, path); n != 1 {
+		t.Errorf("journal has %d records after appending again, want 1", n)
+	}
+}
+
+func TestFileSpoolCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	s, err := apns.OpenFileSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := int32(1); id <= 2; id++ {
+		if _, err := s.Append(testNotification(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn last line is dropped.
+	if err := os.WriteFile(path, append(good, `{"seq":3,"notif`...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = apns.OpenFileSpool(path); err != nil {
+		t.Fatalf("torn last line: %v", err)
+	}
+	pending, _ := s.Pending()
+	s.Close()
+	if len(pending) != 2 {
+		t.Errorf("torn last line: %d pending, want 2", len(pending))
+	}
+
+	// A corrupt line followed by others is an error, and the journal is
+	// left as it was.
+	i := bytes.IndexByte(good, '\n')
+	corrupt := append(append(append([]byte{}, good[:i]...), "\ngarbage\n"...), good[i+1:]...)
+	if err := os.WriteFile(path, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = apns.OpenFileSpool(path); err == nil {
+		s.Close()
+		t.Fatal("corrupt line: no error")
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, corrupt) {
+		t.Errorf("corrupt line: journal rewritten to %q", data)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2013 Cristian Filipov. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apns
+
+// ConnState is a stage in the lifecycle of a Client's connection.
+type ConnState int
+
+const (
+	// The client has a working connection again after reconnecting.
+	Connected ConnState = iota
+
+	// The connection was lost; StateEvent.Err says why.
+	Disconnected
+
+	// The client is about to make a reconnection attempt;
+	// StateEvent.Attempt counts them from zero.
+	Reconnecting
+)
+
+var connStateNames = map[ConnState]string{
+	Connected:    "connected",
+	Disconnected: "disconnected",
+	Reconnecting: "reconnecting",
+}
+
+func (s ConnState) String() string {
+	return connStateNames[s]
+}
+
+// StateEvent describes a change in a Client's connection state.
+type StateEvent struct {
+	State ConnState
+
+	// The reason for a Disconnected event.
+	Err error
+
+	// The attempt number of a Reconnecting event.
+	Attempt int
+}
+
+func (c *Client) onStateChange(ev StateEvent) {
+	for _, h := range c.hooks {
+		if h.OnStateChange != nil {
+			h.OnStateChange(ev)
+		}
+	}
+}